@@ -0,0 +1,208 @@
+// Command ecg-classify scores recorded ECG files offline, without the broker and the database.
+//
+// Every recording, a CSV file or a WFDB record, is split in epochs, filtered, checked for signal
+// quality, and its HRV features are computed and classified as in the gateway:
+//
+//	ecg-classify -model model.json -out results slpdb/slp01a.hea slpdb/slp02a.hea
+//	ecg-classify -fs 250 -column 1 night.csv
+//
+// The hypnogram of a recording lists the start and end of every epoch in seconds, its stage and the
+// probability of every stage. The feature table holds the features of the scorable epochs, with the
+// stage in the last column, in the format elm-train reads. With -out, both are written to the directory
+// as <recording>.hypnogram.csv and <recording>.features.csv; without it, the hypnogram is printed.
+// Without -model, only the features are computed.
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/classify"
+	"github.com/stanleydv12/gateway-classification/src/recording"
+)
+
+func main() {
+	fs := flag.Float64("fs", 0, "sampling rate in Hz of CSV files without a time column")
+	column := flag.Int("column", 0, "CSV column of the ECG, from 0")
+	timeColumn := flag.Int("time-column", -1, "CSV column of the sample times in seconds, -1 for none")
+	signal := flag.String("signal", "", "WFDB signal to read, by index or description, the first ECG signal by default")
+	modelPath := flag.String("model", "", "ELM model bundle")
+	transitionsPath := flag.String("transitions", "", "stage transition matrix smoothing the hypnograms")
+	smooth := flag.Bool("smooth", false, "smooth the hypnograms with a sticky transition matrix when -transitions is not set")
+	stay := flag.Float64("stay", classify.DefaultStayProbability, "probability of a stage lasting another epoch with -smooth")
+	epochLength := flag.Duration("epoch", 30*time.Second, "epoch length")
+	context := flag.Duration("context", 0, "window centred on every epoch its features are computed on, the epoch alone by default")
+	powerline := flag.Float64("powerline", 50, "mains frequency notched out of the ECG, 0 to disable")
+	sqi := flag.Float64("sqi", classify.DefaultQualityThreshold, "signal quality score below which an epoch is unscorable")
+	maxRRCorrection := flag.Float64("max-rr-correction", 0.2, "fraction of corrected RR intervals above which an epoch is unscorable")
+	dimension := flag.Int("entropy-dimension", classify.DefaultNonlinearOptions.EmbeddingDimension, "embedding dimension of sample and approximate entropy")
+	tolerance := flag.Float64("entropy-tolerance", classify.DefaultNonlinearOptions.Tolerance, "tolerance of sample and approximate entropy, relative to the SD of the RR intervals")
+	extended := flag.Bool("extended", false, "compute the 22 extended features instead of 18 when there is no model")
+	out := flag.String("out", "", "directory the hypnograms and feature tables are written to")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var classifier *classify.ELMClassifier
+	if *modelPath != "" {
+		bundle, err := classify.LoadModelBundle(*modelPath)
+		if err != nil {
+			log.Fatalf("Error loading model bundle: %v", err)
+		}
+		if classifier, err = bundle.Classifier(); err != nil {
+			log.Fatalf("Error loading model bundle: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Classifying with model %s\n", bundle.Version)
+	}
+
+	var transitions *classify.TransitionMatrix
+	switch {
+	case *transitionsPath != "":
+		tm, err := classify.LoadTransitionMatrix(*transitionsPath)
+		if err != nil {
+			log.Fatalf("Error loading stage transition matrix: %v", err)
+		}
+		transitions = tm
+	case *smooth && classifier != nil:
+		transitions = classify.NewStickyTransitions(classifier.Labels, *stay)
+	}
+
+	options := classify.PipelineOptions{
+		EpochLength:      epochLength.Seconds(),
+		Context:          context.Seconds(),
+		Powerline:        *powerline,
+		QualityThreshold: *sqi,
+		MaxRRCorrection:  *maxRRCorrection,
+		Nonlinear:        classify.DefaultNonlinearOptions,
+		Features:         classify.FeatureCount,
+	}
+	options.Nonlinear.EmbeddingDimension = *dimension
+	options.Nonlinear.Tolerance = *tolerance
+	if *extended {
+		options.Features = classify.ExtendedFeatureCount
+	}
+
+	if *out != "" {
+		if err := os.MkdirAll(*out, 0o755); err != nil {
+			log.Fatalf("Error creating %s: %v", *out, err)
+		}
+	}
+
+	readOptions := recording.Options{SamplingRate: *fs, Column: *column, TimeColumn: *timeColumn, Signal: *signal}
+	failed := 0
+	for _, path := range flag.Args() {
+		if err := processFile(path, readOptions, classifier, transitions, options, *out); err != nil {
+			fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", path, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// processFile scores one recording and writes or prints its results.
+func processFile(path string, readOptions recording.Options, classifier *classify.ELMClassifier, transitions *classify.TransitionMatrix, options classify.PipelineOptions, out string) error {
+	rec, err := recording.Read(path, readOptions)
+	if err != nil {
+		return err
+	}
+
+	epochs, err := classify.ScoreSignal(rec.Signal, rec.SamplingRate, classifier, transitions, options)
+	if err != nil {
+		return err
+	}
+
+	scorable := 0
+	for _, e := range epochs {
+		if e.Features != nil {
+			scorable++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s at %g Hz, %d epochs, %d scorable\n", rec.Name, rec.Duration(), rec.SamplingRate, len(epochs), scorable)
+
+	var labels []string
+	if classifier != nil {
+		labels = classifier.Labels
+	}
+
+	if out == "" {
+		return writeHypnogram(os.Stdout, epochs, labels)
+	}
+
+	hypnogram, err := os.Create(filepath.Join(out, rec.Name+".hypnogram.csv"))
+	if err != nil {
+		return err
+	}
+	defer hypnogram.Close()
+	if err := writeHypnogram(hypnogram, epochs, labels); err != nil {
+		return err
+	}
+
+	features, err := os.Create(filepath.Join(out, rec.Name+".features.csv"))
+	if err != nil {
+		return err
+	}
+	defer features.Close()
+	return writeFeatures(features, epochs)
+}
+
+// writeHypnogram writes one row per epoch: its index, start and end in seconds, stage, and the
+// probability of every label.
+func writeHypnogram(w io.Writer, epochs []classify.Epoch, labels []string) error {
+	writer := csv.NewWriter(w)
+	header := []string{"epoch", "start", "end", "stage"}
+	for _, label := range labels {
+		header = append(header, "p_"+label)
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for i, e := range epochs {
+		record := []string{
+			strconv.Itoa(i),
+			strconv.FormatFloat(e.Start, 'f', 3, 64),
+			strconv.FormatFloat(e.End, 'f', 3, 64),
+			e.Stage,
+		}
+		for k := range labels {
+			p := ""
+			if e.Probabilities != nil {
+				p = strconv.FormatFloat(e.Probabilities[k], 'f', 4, 64)
+			}
+			record = append(record, p)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeFeatures writes the features of the scorable epochs with their stage.
+func writeFeatures(w io.Writer, epochs []classify.Epoch) error {
+	var features [][]float64
+	var stages []string
+	n := 0
+	for _, e := range epochs {
+		if e.Features == nil {
+			continue
+		}
+		features = append(features, e.Features)
+		stages = append(stages, e.Stage)
+		n = len(e.Features)
+	}
+	return classify.WriteLabeledFeatures(w, classify.HRVFeatureNames[:n], features, stages)
+}
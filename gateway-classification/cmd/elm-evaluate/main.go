@@ -0,0 +1,313 @@
+// Command elm-evaluate measures how well a model stages sleep against expert scoring.
+//
+// Every recording is scored as ecg-classify does, and its epochs are aligned with the sleep stages of
+// its reference annotations, a WFDB annotation file or the annotations of an EDF+ file:
+//
+//	elm-evaluate -model model.json slpdb/slp01a.hea slpdb/slp02a.hea
+//	elm-evaluate -model model.json -annotations night1-Hypnogram.edf night1-PSG.edf
+//	elm-evaluate -model model.json -format json -out evaluation.json slpdb/*.hea
+//
+// -annotations lists the annotation files of the recordings, in the same order. Without it, the
+// annotations of an EDF+ recording are used, and those of a WFDB record are read from the file of
+// the -annotator, <record>.st by default.
+//
+// The report gives the confusion matrix, accuracy, Cohen's kappa and the precision and recall of
+// every stage of all the recordings, and of every subject, one recording each. Epochs the expert did
+// not score and epochs that are unscorable are left out of the metrics and counted apart.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/classify"
+	"github.com/stanleydv12/gateway-classification/src/recording"
+)
+
+// result is the evaluation of a subject, or of all of them.
+type result struct {
+	Subject string `json:"subject,omitempty"`
+	// Referenced is the number of epochs with a reference stage. Of them, Unscored is the number the
+	// expert did not score and Unscorable the number the pipeline could not score; the others are
+	// the Epochs of the evaluation.
+	Referenced int `json:"referenced"`
+	Unscored   int `json:"unscored"`
+	Unscorable int `json:"unscorable"`
+	classify.Evaluation
+}
+
+// report is the evaluation of a model.
+type report struct {
+	Model    string   `json:"model"`
+	Labels   []string `json:"labels"`
+	Overall  result   `json:"overall"`
+	Subjects []result `json:"subjects"`
+}
+
+func main() {
+	modelPath := flag.String("model", "", "ELM model bundle to evaluate")
+	annotationPaths := flag.String("annotations", "", "comma separated reference annotation files of the recordings, in the same order")
+	annotator := flag.String("annotator", "st", "extension of the annotation files of WFDB records without -annotations")
+	fs := flag.Float64("fs", 0, "sampling rate in Hz of CSV files without a time column")
+	column := flag.Int("column", 0, "CSV column of the ECG, from 0")
+	timeColumn := flag.Int("time-column", -1, "CSV column of the sample times in seconds, -1 for none")
+	signal := flag.String("signal", "", "WFDB or EDF signal to read, by index, description or label, the first ECG signal by default")
+	transitionsPath := flag.String("transitions", "", "stage transition matrix smoothing the hypnograms")
+	smooth := flag.Bool("smooth", false, "smooth the hypnograms with a sticky transition matrix when -transitions is not set")
+	stay := flag.Float64("stay", classify.DefaultStayProbability, "probability of a stage lasting another epoch with -smooth")
+	epochLength := flag.Duration("epoch", 30*time.Second, "epoch length of the reference scoring")
+	context := flag.Duration("context", 0, "window centred on every epoch its features are computed on, the epoch alone by default")
+	powerline := flag.Float64("powerline", 50, "mains frequency notched out of the ECG, 0 to disable")
+	sqi := flag.Float64("sqi", classify.DefaultQualityThreshold, "signal quality score below which an epoch is unscorable")
+	maxRRCorrection := flag.Float64("max-rr-correction", 0.2, "fraction of corrected RR intervals above which an epoch is unscorable")
+	dimension := flag.Int("entropy-dimension", classify.DefaultNonlinearOptions.EmbeddingDimension, "embedding dimension of sample and approximate entropy")
+	tolerance := flag.Float64("entropy-tolerance", classify.DefaultNonlinearOptions.Tolerance, "tolerance of sample and approximate entropy, relative to the SD of the RR intervals")
+	format := flag.String("format", "text", "format of the report: text or json")
+	out := flag.String("out", "", "file the report is written to, standard output by default")
+	verbose := flag.Bool("v", false, "print the accuracy and kappa of every recording as it is evaluated")
+	flag.Parse()
+
+	if flag.NArg() == 0 || *modelPath == "" || (*format != "text" && *format != "json") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var annotations []string
+	if *annotationPaths != "" {
+		annotations = strings.Split(*annotationPaths, ",")
+		if len(annotations) != flag.NArg() {
+			log.Fatalf("%d annotation files for %d recordings", len(annotations), flag.NArg())
+		}
+	}
+
+	bundle, err := classify.LoadModelBundle(*modelPath)
+	if err != nil {
+		log.Fatalf("Error loading model bundle: %v", err)
+	}
+	classifier, err := bundle.Classifier()
+	if err != nil {
+		log.Fatalf("Error loading model bundle: %v", err)
+	}
+
+	var transitions *classify.TransitionMatrix
+	switch {
+	case *transitionsPath != "":
+		if transitions, err = classify.LoadTransitionMatrix(*transitionsPath); err != nil {
+			log.Fatalf("Error loading stage transition matrix: %v", err)
+		}
+	case *smooth:
+		transitions = classify.NewStickyTransitions(classifier.Labels, *stay)
+	}
+
+	options := classify.PipelineOptions{
+		EpochLength:      epochLength.Seconds(),
+		Context:          context.Seconds(),
+		Powerline:        *powerline,
+		QualityThreshold: *sqi,
+		MaxRRCorrection:  *maxRRCorrection,
+		Nonlinear:        classify.DefaultNonlinearOptions,
+	}
+	options.Nonlinear.EmbeddingDimension = *dimension
+	options.Nonlinear.Tolerance = *tolerance
+
+	// The reference stages a model does not predict are kept, so that their epochs count as errors.
+	labels := append([]string(nil), classifier.Labels...)
+	for _, label := range classify.DefaultSleepStageLabels {
+		if !contains(labels, label) {
+			labels = append(labels, label)
+		}
+	}
+
+	r := report{
+		Model:   bundle.Version,
+		Labels:  labels,
+		Overall: result{Evaluation: classify.NewConfusionMatrix(labels).Evaluate()},
+	}
+	if r.Model == "" {
+		r.Model = *modelPath
+	}
+
+	readOptions := recording.Options{SamplingRate: *fs, Column: *column, TimeColumn: *timeColumn, Signal: *signal}
+	failed := 0
+	for i, path := range flag.Args() {
+		annotationPath := ""
+		if annotations != nil {
+			annotationPath = annotations[i]
+		}
+		subject, err := evaluateFile(path, annotationPath, *annotator, readOptions, classifier, transitions, options, labels)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error evaluating %s: %v\n", path, err)
+			failed++
+			continue
+		}
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "%s: %d epochs evaluated, accuracy %.3f, kappa %.3f\n",
+				subject.Subject, subject.Epochs, subject.Accuracy, subject.Kappa)
+		}
+
+		r.Subjects = append(r.Subjects, subject)
+		r.Overall.Referenced += subject.Referenced
+		r.Overall.Unscored += subject.Unscored
+		r.Overall.Unscorable += subject.Unscorable
+		if err := r.Overall.Confusion.Merge(subject.Confusion); err != nil {
+			log.Fatal(err)
+		}
+	}
+	r.Overall.Evaluation = r.Overall.Confusion.Evaluate()
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Error creating %s: %v", *out, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(r)
+	} else {
+		err = writeReport(w, r)
+	}
+	if err != nil {
+		log.Fatalf("Error writing the report: %v", err)
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// evaluateFile scores a recording and compares its epochs with the reference stages.
+func evaluateFile(path, annotationPath, annotator string, readOptions recording.Options, classifier *classify.ELMClassifier, transitions *classify.TransitionMatrix, options classify.PipelineOptions, labels []string) (result, error) {
+	rec, err := recording.Read(path, readOptions)
+	if err != nil {
+		return result{}, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	switch {
+	case annotationPath != "":
+	case ext == ".edf" || ext == ".rec":
+		annotationPath = path
+	case ext == ".hea" || ext == ".dat" || ext == "":
+		annotationPath = strings.TrimSuffix(path, filepath.Ext(path)) + "." + annotator
+	default:
+		return result{}, fmt.Errorf("no reference annotations, set them with -annotations")
+	}
+	annotations, err := recording.ReadAnnotations(annotationPath, rec.SamplingRate)
+	if err != nil {
+		return result{}, err
+	}
+
+	references := recording.SleepStages(annotations, options.EpochLength)
+	if len(references) == 0 {
+		return result{}, fmt.Errorf("%s: no scored sleep stages", annotationPath)
+	}
+
+	// The epochs are scored on the grid of the reference scoring.
+	options.Offset = math.Mod(references[0].Start, options.EpochLength)
+	epochs, err := classify.ScoreSignal(rec.Signal, rec.SamplingRate, classifier, transitions, options)
+	if err != nil {
+		return result{}, err
+	}
+
+	reference := make(map[int]string, len(references))
+	for _, e := range references {
+		reference[epochIndex(e.Start, options)] = e.Stage
+	}
+
+	subject := result{Subject: rec.Name}
+	confusion := classify.NewConfusionMatrix(labels)
+	for _, e := range epochs {
+		stage, ok := reference[epochIndex(e.Start, options)]
+		if !ok {
+			// The expert did not score this part of the recording.
+			continue
+		}
+		subject.Referenced++
+		switch {
+		case e.Stage == classify.UnscorableStage:
+			subject.Unscorable++
+		case !confusion.Add(stage, e.Stage):
+			subject.Unscored++
+		}
+	}
+	subject.Evaluation = confusion.Evaluate()
+	return subject, nil
+}
+
+// epochIndex returns the index on the epoch grid of the epoch starting at start seconds.
+func epochIndex(start float64, options classify.PipelineOptions) int {
+	return int(math.Round((start - options.Offset) / options.EpochLength))
+}
+
+// writeReport writes the report as text: the metrics of all the recordings, their confusion matrix,
+// and the metrics of every subject.
+func writeReport(w io.Writer, r report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Fprintf(tw, "Model %s\n", r.Model)
+	fmt.Fprintf(tw, "Epochs with a reference stage %d: %d evaluated, %d unscored by the reference, %d unscorable\n",
+		r.Overall.Referenced, r.Overall.Epochs, r.Overall.Unscored, r.Overall.Unscorable)
+	fmt.Fprintf(tw, "Accuracy %.3f, Cohen's kappa %.3f\n\n", r.Overall.Accuracy, r.Overall.Kappa)
+
+	fmt.Fprint(tw, "stage\tprecision\trecall\tf1\tsupport\tpredicted\t\n")
+	for _, c := range r.Overall.Classes {
+		fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%.3f\t%d\t%d\t\n", c.Label, c.Precision, c.Recall, c.F1, c.Support, c.Predicted)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprint(tw, "reference \\ predicted\t")
+	for _, label := range r.Labels {
+		fmt.Fprintf(tw, "%s\t", label)
+	}
+	fmt.Fprintln(tw)
+	for i, label := range r.Labels {
+		fmt.Fprintf(tw, "%s\t", label)
+		for _, n := range r.Overall.Confusion.Counts[i] {
+			fmt.Fprintf(tw, "%d\t", n)
+		}
+		fmt.Fprintln(tw)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprint(tw, "subject\tepochs\taccuracy\tkappa\t")
+	for _, label := range r.Labels {
+		fmt.Fprintf(tw, "recall %s\t", label)
+	}
+	fmt.Fprintln(tw)
+	for _, s := range r.Subjects {
+		fmt.Fprintf(tw, "%s\t%d\t%.3f\t%.3f\t", s.Subject, s.Epochs, s.Accuracy, s.Kappa)
+		for _, c := range s.Classes {
+			if c.Support == 0 {
+				fmt.Fprint(tw, "-\t")
+				continue
+			}
+			fmt.Fprintf(tw, "%.3f\t", c.Recall)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func contains(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
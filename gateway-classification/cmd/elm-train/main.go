@@ -0,0 +1,190 @@
+// Command elm-train trains the sleep stage ELM model of the gateway from labeled HRV feature vectors.
+//
+// Every feature file is a CSV of one labeled night, one epoch per row with the features in the
+// F01..F18 or F01..F22 order and the sleep stage in the last column:
+//
+//	elm-train -features night1.csv,night2.csv -hidden 200 -ridge 0.01 -version cohort-a-2024-06 -out model.json
+//
+// The features are normalised with the -scaler fitted on the training features and stored in the
+// bundle: zscore, minmax, or baseline, which standardises every night with its own statistics, as
+// the gateway does with the epochs of a session. none trains on the raw features.
+//
+// The model is written as a model bundle, to be loaded with ELM_MODEL_PATH. With -csv, it is also
+// written as the legacy input_weight.csv and output_weight.csv of ELM_INPUT_WEIGHT_PATH and
+// ELM_OUTPUT_WEIGHT_PATH, which have no scaler, so -scaler none should be used with them.
+// With -transitions, the stage transition matrix of the nights is also learned, to be loaded
+// with HMM_TRANSITIONS_PATH.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/classify"
+)
+
+func main() {
+	featurePaths := flag.String("features", "", "comma separated labeled feature CSV files, one night each")
+	hidden := flag.Int("hidden", 200, "number of hidden nodes")
+	activation := flag.String("activation", "sigmoid", "activation of the hidden layer")
+	ridge := flag.Float64("ridge", 1e-2, "ridge regularisation of the output weights")
+	seed := flag.Int64("seed", 1, "seed of the random input weights")
+	labels := flag.String("labels", strings.Join(classify.DefaultSleepStageLabels, ","), "comma separated labels of the output nodes")
+	scaler := flag.String("scaler", classify.ScalerZScore, "normalisation of the features: zscore, minmax, baseline or none")
+	version := flag.String("version", "", "version of the model, stored in the bundle")
+	description := flag.String("description", "", "description of the model, stored in the bundle")
+	out := flag.String("out", "model.json", "file the model bundle is written to")
+	csvDir := flag.String("csv", "", "directory the model is also written to as CSV weights")
+	transitions := flag.String("transitions", "", "file the learned stage transition matrix is written to")
+	flag.Parse()
+
+	if *featurePaths == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	classes := strings.Split(*labels, ",")
+	for i := range classes {
+		classes[i] = strings.TrimSpace(classes[i])
+	}
+
+	var features [][]float64
+	var targets []string
+	var nights [][]string
+	var recordings []int
+	for night, path := range strings.Split(*featurePaths, ",") {
+		x, y, err := readFeatureFile(strings.TrimSpace(path))
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", path, err)
+		}
+		features = append(features, x...)
+		targets = append(targets, y...)
+		nights = append(nights, y)
+		for range y {
+			recordings = append(recordings, night)
+		}
+	}
+
+	// Epochs without a sleep stage, such as unscorable ones, are not trained on.
+	known := map[string]bool{}
+	for _, class := range classes {
+		known[class] = true
+	}
+	var trainFeatures [][]float64
+	var trainTargets []string
+	var trainRecordings []int
+	for i, target := range targets {
+		if known[target] {
+			trainFeatures = append(trainFeatures, features[i])
+			trainTargets = append(trainTargets, target)
+			trainRecordings = append(trainRecordings, recordings[i])
+		}
+	}
+	fmt.Printf("Training on %d of %d epochs from %d nights\n", len(trainFeatures), len(features), len(nights))
+
+	if *scaler == "none" {
+		*scaler = ""
+	}
+	classifier, err := classify.TrainELM(trainFeatures, trainTargets, classes, classify.ELMTrainingOptions{
+		Hidden:     *hidden,
+		Activation: *activation,
+		Ridge:      *ridge,
+		Seed:       *seed,
+		Scaler:     *scaler,
+		Recordings: trainRecordings,
+	})
+	if err != nil {
+		log.Fatalf("Error training ELM model: %v", err)
+	}
+
+	correct := 0
+	nightClassifiers := map[int]*classify.ELMClassifier{}
+	for i, x := range trainFeatures {
+		night := trainRecordings[i]
+		if nightClassifiers[night] == nil {
+			nightClassifiers[night] = scoped(classifier, trainFeatures, trainRecordings, night)
+		}
+		label, _, err := nightClassifiers[night].Classify(x)
+		if err == nil && label == trainTargets[i] {
+			correct++
+		}
+	}
+	fmt.Printf("Training accuracy: %.1f%%\n", 100*float64(correct)/float64(len(trainFeatures)))
+	fmt.Printf("Softmax temperature: %.3g\n", classifier.Temperature)
+
+	if *version == "" {
+		*version = time.Now().UTC().Format("20060102-150405")
+	}
+	bundle, err := classify.NewModelBundle(classifier, *activation, *version, *description)
+	if err != nil {
+		log.Fatalf("Error creating model bundle: %v", err)
+	}
+	if err := bundle.Save(*out); err != nil {
+		log.Fatalf("Error saving model bundle: %v", err)
+	}
+	fmt.Printf("Saved model %s to %s\n", bundle.Version, *out)
+
+	if *csvDir != "" {
+		if classifier.Scaler != nil {
+			fmt.Println("Warning: CSV weights do not hold the scaler, use -scaler none for CSV models")
+		}
+		if err := os.MkdirAll(*csvDir, 0o755); err != nil {
+			log.Fatalf("Error creating %s: %v", *csvDir, err)
+		}
+		inputWeightPath := filepath.Join(*csvDir, "input_weight.csv")
+		outputWeightPath := filepath.Join(*csvDir, "output_weight.csv")
+		if err := classifier.Model.Save(inputWeightPath, outputWeightPath); err != nil {
+			log.Fatalf("Error saving ELM model: %v", err)
+		}
+		fmt.Printf("Saved model to %s and %s\n", inputWeightPath, outputWeightPath)
+	}
+
+	if *transitions != "" {
+		tm, err := classify.LearnTransitions(classes, nights, 1)
+		if err != nil {
+			log.Fatalf("Error learning stage transitions: %v", err)
+		}
+		if err := tm.Save(*transitions); err != nil {
+			log.Fatalf("Error saving stage transitions: %v", err)
+		}
+		fmt.Println("Saved stage transitions to", *transitions)
+	}
+}
+
+// scoped returns the classifier of a night, with its baseline scaler fitted on the night's features.
+func scoped(classifier *classify.ELMClassifier, features [][]float64, recordings []int, night int) *classify.ELMClassifier {
+	if !classifier.NeedsBaseline() {
+		return classifier
+	}
+
+	var recording [][]float64
+	for i, x := range features {
+		if recordings[i] == night {
+			recording = append(recording, x)
+		}
+	}
+	scaler, err := classify.FitScaler(recording, classify.ScalerBaseline)
+	if err != nil {
+		return classifier
+	}
+	c, err := classifier.WithScaler(scaler)
+	if err != nil {
+		return classifier
+	}
+	return c
+}
+
+func readFeatureFile(path string) ([][]float64, []string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	return classify.ReadLabeledFeatures(file)
+}
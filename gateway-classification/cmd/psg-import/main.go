@@ -0,0 +1,182 @@
+// Command psg-import imports a polysomnography recording and its expert scoring into the database of
+// the gateway, as a night of reference data to validate the staging against.
+//
+// The ECG of the recording, a WFDB record, an EDF or EDF+ file, or a CSV file, becomes the ECG of a new
+// sleep data of the patient, and the sleep stages scored in the annotations become its reference stages:
+//
+//	psg-import -patient 1 -annotations slpdb/slp01a.st slpdb/slp01a.hea
+//	psg-import -patient 1 -annotations night1-Hypnogram.edf -signal "ECG" night1-PSG.edf
+//
+// The annotations are a WFDB annotation file, or an EDF+ file such as a Sleep-EDF hypnogram. Without
+// -annotations, the annotations of an EDF+ recording are used. The database is configured with the
+// environment variables of the gateway.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/database"
+	"github.com/stanleydv12/gateway-classification/src/entity"
+	"github.com/stanleydv12/gateway-classification/src/recording"
+	"gorm.io/gorm"
+)
+
+// importChunkSize is the number of samples converted to ECG rows at a time, so that a night of ECG is
+// not held in memory twice.
+const importChunkSize = 100000
+
+// insertBatchSize is the number of ECG rows sent to the database per INSERT statement.
+const insertBatchSize = 500
+
+func main() {
+	patientID := flag.Uint("patient", 0, "ID of the patient the recording belongs to")
+	annotationsPath := flag.String("annotations", "", "WFDB annotation or EDF+ file holding the scored sleep stages")
+	fs := flag.Float64("fs", 0, "sampling rate in Hz of CSV files without a time column")
+	column := flag.Int("column", 0, "CSV column of the ECG, from 0")
+	timeColumn := flag.Int("time-column", -1, "CSV column of the sample times in seconds, -1 for none")
+	signal := flag.String("signal", "", "WFDB or EDF signal to read, by index, description or label, the first ECG signal by default")
+	start := flag.String("start", "", "start time of the recording, RFC 3339, when the file does not record it")
+	epochLength := flag.Duration("epoch", 30*time.Second, "epoch length of the scoring")
+	source := flag.String("source", "", "source of the reference stages, e.g. the database or the scorer, the annotation file by default")
+	flag.Parse()
+
+	if flag.NArg() != 1 || *patientID == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+
+	rec, annotations, err := readRecording(path, recording.Options{SamplingRate: *fs, Column: *column, TimeColumn: *timeColumn, Signal: *signal})
+	if err != nil {
+		log.Fatalf("Error reading %s: %v", path, err)
+	}
+
+	if *start != "" {
+		if rec.Start, err = time.Parse(time.RFC3339, *start); err != nil {
+			log.Fatalf("Error parsing -start: %v", err)
+		}
+	}
+	if rec.Start.IsZero() {
+		log.Fatalf("%s does not record its start time, set it with -start", path)
+	}
+
+	stageSource := *source
+	if *annotationsPath != "" {
+		if annotations, err = recording.ReadAnnotations(*annotationsPath, rec.SamplingRate); err != nil {
+			log.Fatalf("Error reading %s: %v", *annotationsPath, err)
+		}
+		if stageSource == "" {
+			stageSource = *annotationsPath
+		}
+	} else if stageSource == "" {
+		stageSource = path
+	}
+
+	epochs := recording.SleepStages(annotations, epochLength.Seconds())
+	if len(epochs) == 0 {
+		fmt.Fprintf(os.Stderr, "%s: no scored sleep stages, importing the ECG only\n", rec.Name)
+	}
+
+	db, err := database.OpenDatabase()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var patient entity.Patient
+	if err := db.First(&patient, *patientID).Error; err != nil {
+		log.Fatalf("Error finding patient %d: %v", *patientID, err)
+	}
+
+	sleepData, err := importRecording(db, patient.ID, rec, epochs, stageSource)
+	if err != nil {
+		log.Fatalf("Error importing %s: %v", path, err)
+	}
+	fmt.Fprintf(os.Stderr, "%s: %s at %g Hz imported as sleep data %d of patient %d, %d reference epochs\n",
+		rec.Name, rec.Duration(), rec.SamplingRate, sleepData.ID, patient.ID, len(epochs))
+}
+
+// readRecording reads the recording at path, and the annotations it embeds when it is an EDF+ file.
+func readRecording(path string, options recording.Options) (*recording.Recording, []recording.Annotation, error) {
+	rec, err := recording.Read(path, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rec.Signal) == 0 || rec.SamplingRate <= 0 {
+		return nil, nil, fmt.Errorf("no samples")
+	}
+
+	var annotations []recording.Annotation
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".edf", ".rec":
+		if annotations, err = recording.ReadEDFAnnotations(path); err != nil {
+			return nil, nil, err
+		}
+	}
+	return rec, annotations, nil
+}
+
+// importRecording stores the recording as a new sleep data of the patient, in a single transaction.
+//
+// As the gateway does, the first ECG has a ReferenceID of 0 and is stored as the FirstECGID of the
+// sleep data; the following ones reference it. Every scored epoch becomes a reference stage.
+func importRecording(db *gorm.DB, patientID uint, rec *recording.Recording, epochs []recording.ScoredEpoch, source string) (entity.SleepData, error) {
+	sleepData := entity.SleepData{
+		PatientID:      patientID,
+		FirstInputTime: rec.SampleTime(0),
+		LastInputTime:  rec.SampleTime(len(rec.Signal) - 1),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&sleepData).Error; err != nil {
+			return err
+		}
+
+		first := rec.ECG(patientID, sleepData.ID, 0, 1)[0]
+		if err := tx.Create(&first).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&sleepData).Update("first_ecg_id", first.ID).Error; err != nil {
+			return err
+		}
+		sleepData.FirstECGID = first.ID
+
+		for from := 1; from < len(rec.Signal); from += importChunkSize {
+			to := min(from+importChunkSize, len(rec.Signal))
+			samples := rec.ECG(patientID, sleepData.ID, from, to)
+			for i := range samples {
+				samples[i].ReferenceID = first.ID
+			}
+			if err := tx.CreateInBatches(samples, insertBatchSize).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(epochs) == 0 {
+			return nil
+		}
+		stages := make([]entity.ReferenceStage, len(epochs))
+		for i, e := range epochs {
+			stages[i] = entity.ReferenceStage{
+				PatientID:   patientID,
+				SleepDataID: sleepData.ID,
+				Value:       e.Stage,
+				Source:      source,
+				StartTime:   rec.Start.Add(seconds(e.Start)),
+				EndTime:     rec.Start.Add(seconds(e.End)),
+			}
+		}
+		return tx.CreateInBatches(stages, insertBatchSize).Error
+	})
+	return sleepData, err
+}
+
+// seconds converts a time in seconds to a duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -14,15 +14,28 @@ func main() {
 	// Setup Database
 	db := database.SetupDatabase()
 	handler.SetDBInstance(db)
+	handler.SetupPatients()
+
+	// Setup write-ahead log for ingested samples
+	handler.SetupIngestLog()
+
+	// Setup sleep sessions and sample timing
+	handler.SetupSessions()
+	handler.SetupClocks()
+
+	// Setup sleep stage classifier
+	handler.SetupClassifier()
+
+	// Resume the sessions left open by a previous run
+	handler.RecoverSessions()
 
 	// Setup Mqtt
 	mqtt.SetupMqtt()
+	handler.SetPublisher(mqtt.PubTo)
 
 	// Application set to listen
 	mqtt.Sub(mqtt.Client)
 
-	handler.StartTimer()
-
 	// Block the main function with a select statement
 	select {}
 }
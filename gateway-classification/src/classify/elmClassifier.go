@@ -1 +1,206 @@
-package classify
\ No newline at end of file
+package classify
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ActivationFunc is the activation applied to every hidden node of an ELM.
+type ActivationFunc func(float64) float64
+
+var activations = map[string]ActivationFunc{
+	"sig":     sigmoid,
+	"sigmoid": sigmoid,
+	"tanh":    math.Tanh,
+	"relu":    relu,
+	"sin":     math.Sin,
+	"hardlim": hardlim,
+	"tribas":  tribas,
+	"radbas":  radbas,
+	"linear":  linear,
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+func relu(x float64) float64 {
+	return math.Max(0, x)
+}
+
+func hardlim(x float64) float64 {
+	if x >= 0 {
+		return 1
+	}
+	return 0
+}
+
+func tribas(x float64) float64 {
+	return math.Max(0, 1-math.Abs(x))
+}
+
+func radbas(x float64) float64 {
+	return math.Exp(-x * x)
+}
+
+func linear(x float64) float64 {
+	return x
+}
+
+// ParseActivation returns the activation with the given name: sig, sigmoid, tanh, relu, sin,
+// hardlim, tribas, radbas or linear.
+func ParseActivation(name string) (ActivationFunc, error) {
+	activation, ok := activations[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil, fmt.Errorf("unknown activation %q", name)
+	}
+	return activation, nil
+}
+
+// DefaultSleepStageLabels are the classes of a sleep-stage ELM, in the order of its output nodes.
+var DefaultSleepStageLabels = []string{"AWAKE", "N1", "N2", "N3", "REM"}
+
+// ELMClassifier runs the forward pass of an Extreme Learning Machine.
+//
+// The hidden layer is activation(InputWeight × features + BiasInputWeight), the output layer
+// multiplies it with OutputWeight, and the label of the largest output is the predicted class.
+type ELMClassifier struct {
+	Model      *ELMModel
+	Activation ActivationFunc
+	Labels     []string
+	// Scaler, if set, normalises the features before the forward pass. An unfitted baseline scaler
+	// must be replaced with one fitted on the recording, see WithScaler.
+	Scaler *Scaler
+	// Temperature scales the outputs into probabilities, see Probabilities. 0 uses DefaultTemperature.
+	Temperature float64
+}
+
+// DefaultTemperature is the softmax temperature of classifiers that were not calibrated. The outputs
+// of an ELM approximate one-hot targets, so a margin of 1 between two outputs is a likelihood ratio
+// of about 55.
+const DefaultTemperature = 0.25
+
+// NewELMClassifier creates a classifier for the model after checking its dimensions.
+//
+// The output weight may be stored as hidden × classes or as classes × hidden.
+func NewELMClassifier(model *ELMModel, activation string, labels []string) (*ELMClassifier, error) {
+	if model == nil {
+		return nil, errors.New("nil ELM model")
+	}
+
+	activationFunc, err := ParseActivation(activation)
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := model.InputWeight.Rows
+	if hidden == 0 || model.InputWeight.Cols == 0 {
+		return nil, errors.New("empty input weight")
+	}
+	if model.BiasInputWeight.Rows != hidden {
+		return nil, fmt.Errorf("bias has %d rows, input weight has %d", model.BiasInputWeight.Rows, hidden)
+	}
+
+	classes := 0
+	switch {
+	case model.OutputWeight.Rows == hidden:
+		classes = model.OutputWeight.Cols
+	case model.OutputWeight.Cols == hidden:
+		classes = model.OutputWeight.Rows
+	default:
+		return nil, fmt.Errorf("output weight is %dx%d, expected %d hidden nodes",
+			model.OutputWeight.Rows, model.OutputWeight.Cols, hidden)
+	}
+	if classes != len(labels) {
+		return nil, fmt.Errorf("model has %d classes, got %d labels", classes, len(labels))
+	}
+
+	return &ELMClassifier{
+		Model:      model,
+		Activation: activationFunc,
+		Labels:     labels,
+	}, nil
+}
+
+// Features returns the number of features the classifier expects.
+func (c *ELMClassifier) Features() int {
+	return c.Model.InputWeight.Cols
+}
+
+// NeedsBaseline reports whether the features are normalised against the baseline of their recording.
+func (c *ELMClassifier) NeedsBaseline() bool {
+	return c.Scaler != nil && c.Scaler.Method == ScalerBaseline
+}
+
+// WithScaler returns a copy of the classifier normalising the features with the given scaler.
+func (c *ELMClassifier) WithScaler(scaler *Scaler) (*ELMClassifier, error) {
+	if err := scaler.Validate(c.Features()); err != nil {
+		return nil, err
+	}
+	scoped := *c
+	scoped.Scaler = scaler
+	return &scoped, nil
+}
+
+// Scores returns the output of every class for the given feature vector.
+func (c *ELMClassifier) Scores(features []float64) ([]float64, error) {
+	input := c.Model.InputWeight
+	if len(features) != input.Cols {
+		return nil, fmt.Errorf("expected %d features, got %d", input.Cols, len(features))
+	}
+	if c.Scaler != nil {
+		if !c.Scaler.Fitted() {
+			return nil, errors.New("the baseline scaler is not fitted on the recording")
+		}
+		features = c.Scaler.Transform(features)
+	}
+
+	hidden := make([]float64, input.Rows)
+	input.MulVecInto(hidden, features)
+	for i := range hidden {
+		hidden[i] = c.Activation(hidden[i] + c.Model.BiasInputWeight.Data[i][0])
+	}
+
+	output := c.Model.OutputWeight
+	scores := make([]float64, len(c.Labels))
+	if output.Rows == input.Rows {
+		output.VecMulInto(scores, hidden)
+	} else {
+		output.MulVecInto(scores, hidden)
+	}
+
+	return scores, nil
+}
+
+// Probabilities turns the outputs of the classifier into the probability of every class, the softmax
+// of the outputs divided by the temperature. With a temperature calibrated on the training set, they
+// estimate how likely each class is given the features, which smoothing uses as emission probabilities.
+func (c *ELMClassifier) Probabilities(scores []float64) []float64 {
+	temperature := c.Temperature
+	if temperature <= 0 {
+		temperature = DefaultTemperature
+	}
+	return SoftmaxWithTemperature(scores, temperature)
+}
+
+// Classify returns the label of the class with the largest output, and the outputs of every class.
+func (c *ELMClassifier) Classify(features []float64) (string, []float64, error) {
+	scores, err := c.Scores(features)
+	if err != nil {
+		return "", nil, err
+	}
+
+	best := 0
+	for k, score := range scores {
+		if math.IsNaN(score) {
+			return "", scores, errors.New("NaN output, check the features")
+		}
+		if score > scores[best] {
+			best = k
+		}
+	}
+
+	return c.Labels[best], scores, nil
+}
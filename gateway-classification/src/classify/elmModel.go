@@ -2,11 +2,14 @@ package classify
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"strconv"
 	"strings"
 )
 
+// RealMatrix is a dense Rows × Cols matrix stored row by row. Its operations are in realMatrix.go.
 type RealMatrix struct {
 	Rows int
 	Cols int
@@ -60,26 +63,42 @@ func NewELMModel(inputWeightFilePath, outputWeightFilePath string) (*ELMModel, e
 func convertListCsvTo2dArr(input []string, useBias bool) (Weight, error) {
 	var weight Weight
 
-	listSize := len(input)
+	// Blank lines, such as the one after a trailing newline, are not rows.
+	var lines []string
+	for _, line := range input {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	listSize := len(lines)
 	if listSize == 0 {
 		return weight, errors.New("empty input list")
 	}
 
-	csvElementSize := len(strings.Split(input[0], ","))
-	for _, line := range input {
-		if len(strings.Split(line, ",")) != csvElementSize {
-			return weight, errors.New("invalid CSV length")
-		}
+	csvElementSize := len(strings.Split(lines[0], ","))
+	if useBias && csvElementSize < 2 {
+		return weight, errors.New("expected weights and a bias on every line")
 	}
 
 	weightData := make([][]float64, listSize)
 	biasWeightData := make([][]float64, listSize)
 
-	for i, line := range input {
+	for i, line := range lines {
 		splittedLine := strings.Split(line, ",")
+		if len(splittedLine) != csvElementSize {
+			return weight, fmt.Errorf("invalid CSV length on line %d: %d values, expected %d", i+1, len(splittedLine), csvElementSize)
+		}
 		temp := make([]float64, len(splittedLine))
 		for j, val := range splittedLine {
-			temp[j], _ = strconv.ParseFloat(strings.TrimSpace(val), 64)
+			f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+			if err != nil {
+				return weight, fmt.Errorf("line %d, column %d: %w", i+1, j+1, err)
+			}
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return weight, fmt.Errorf("line %d, column %d: weight is %g", i+1, j+1, f)
+			}
+			temp[j] = f
 		}
 		if useBias {
 			weightData[i] = temp[:len(temp)-1]
@@ -94,3 +113,29 @@ func convertListCsvTo2dArr(input []string, useBias bool) (Weight, error) {
 
 	return weight, nil
 }
+
+// Save writes the model as the two CSV files NewELMModel reads: the input weights with the bias as
+// last column, and the output weights.
+func (m *ELMModel) Save(inputWeightFilePath, outputWeightFilePath string) error {
+	inputWeight := make([][]float64, m.InputWeight.Rows)
+	for i, row := range m.InputWeight.Data {
+		inputWeight[i] = append(append([]float64(nil), row...), m.BiasInputWeight.Data[i][0])
+	}
+
+	if err := ioutil.WriteFile(inputWeightFilePath, []byte(formatCsv(inputWeight)), 0o644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outputWeightFilePath, []byte(formatCsv(m.OutputWeight.Data)), 0o644)
+}
+
+func formatCsv(data [][]float64) string {
+	lines := make([]string, len(data))
+	for i, row := range data {
+		values := make([]string, len(row))
+		for j, val := range row {
+			values[j] = strconv.FormatFloat(val, 'g', -1, 64)
+		}
+		lines[i] = strings.Join(values, ",")
+	}
+	return strings.Join(lines, "\n")
+}
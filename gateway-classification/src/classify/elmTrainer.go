@@ -0,0 +1,224 @@
+package classify
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// ELMTrainingOptions configures TrainELM.
+type ELMTrainingOptions struct {
+	// Hidden is the number of hidden nodes.
+	Hidden int
+	// Activation is the name of the hidden layer's activation, see ParseActivation.
+	Activation string
+	// Ridge is the regularisation added to the diagonal before the pseudoinverse. 0 is the plain
+	// Moore–Penrose pseudoinverse, computed through the SVD of the hidden layer.
+	Ridge float64
+	// Seed seeds the random input weights and biases.
+	Seed int64
+	// Scaler is the normalisation of the features, ScalerZScore, ScalerMinMax or ScalerBaseline, stored
+	// in the classifier. Empty trains on the raw features.
+	Scaler string
+	// Recordings holds the recording of every sample, which ScalerBaseline normalises separately.
+	Recordings []int
+}
+
+// TrainELM trains an Extreme Learning Machine on labeled feature vectors.
+//
+// labels[i] is the label of features[i], and classes are the labels of the output nodes, in order.
+//
+// With a Scaler, the features are normalised first. Input weights are drawn uniformly from [-1, 1],
+// and biases from [0, 1]. The output weights are the ridge-regularised least squares solution
+// β = (HᵀH + λI)⁻¹HᵀT of the hidden layer outputs H against the one-hot targets T. When there are
+// fewer samples than hidden nodes, the same β is computed as Hᵀ(HHᵀ + λI)⁻¹T. The softmax temperature
+// of the outputs is then calibrated on the training samples, see FitTemperature.
+func TrainELM(features [][]float64, labels []string, classes []string, options ELMTrainingOptions) (*ELMClassifier, error) {
+	if len(features) == 0 {
+		return nil, errors.New("no training samples")
+	}
+	if len(features) != len(labels) {
+		return nil, fmt.Errorf("%d feature vectors for %d labels", len(features), len(labels))
+	}
+	if options.Hidden <= 0 {
+		return nil, errors.New("hidden node count must be positive")
+	}
+
+	activation, err := ParseActivation(options.Activation)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := len(features[0])
+	for i, x := range features {
+		if len(x) != inputs {
+			return nil, fmt.Errorf("sample %d has %d features, expected %d", i, len(x), inputs)
+		}
+	}
+
+	var scaler *Scaler
+	switch options.Scaler {
+	case "":
+	case ScalerBaseline:
+		if len(options.Recordings) != len(features) {
+			return nil, fmt.Errorf("%d recordings for %d samples, a baseline scaler needs the recording of every sample", len(options.Recordings), len(features))
+		}
+		features, err = normaliseRecordings(features, options.Recordings)
+		if err != nil {
+			return nil, err
+		}
+		scaler = &Scaler{Method: ScalerBaseline}
+	default:
+		scaler, err = FitScaler(features, options.Scaler)
+		if err != nil {
+			return nil, err
+		}
+		scaled := make([][]float64, len(features))
+		for i, x := range features {
+			scaled[i] = scaler.Transform(x)
+		}
+		features = scaled
+	}
+
+	classIndex := labelIndex(classes)
+	targets := NewRealMatrix(len(labels), len(classes))
+	for i, label := range labels {
+		k, ok := classIndex[label]
+		if !ok {
+			return nil, fmt.Errorf("sample %d has unknown label %q", i, label)
+		}
+		targets.Data[i][k] = 1
+	}
+
+	random := rand.New(rand.NewSource(options.Seed))
+	inputWeight := NewRealMatrix(options.Hidden, inputs)
+	bias := NewRealMatrix(options.Hidden, 1)
+	for i, row := range inputWeight.Data {
+		for j := range row {
+			row[j] = 2*random.Float64() - 1
+		}
+		bias.Data[i][0] = random.Float64()
+	}
+
+	x, err := NewRealMatrixFromData(features)
+	if err != nil {
+		return nil, err
+	}
+	hidden := x.Mul(inputWeight.Transpose())
+	hidden.AddRowVectorInPlace(bias.Col(0))
+	hidden.ApplyInPlace(activation)
+
+	outputWeight, err := ridgeSolve(hidden, targets, options.Ridge)
+	if err != nil {
+		return nil, err
+	}
+
+	model := &ELMModel{
+		InputWeight:     inputWeight,
+		BiasInputWeight: bias,
+		OutputWeight:    outputWeight,
+	}
+	classifier, err := NewELMClassifier(model, options.Activation, classes)
+	if err != nil {
+		return nil, err
+	}
+	classifier.Scaler = scaler
+	classifier.Temperature = FitTemperature(hidden.Mul(outputWeight).Data, targets.Data)
+	return classifier, nil
+}
+
+// Bounds of the softmax temperature searched by FitTemperature.
+const (
+	minTemperature = 0.01
+	maxTemperature = 10
+)
+
+// FitTemperature returns the softmax temperature minimising the negative log-likelihood of the targets,
+// one-hot rows, given the outputs of a classifier. The search is a golden-section search on the log of
+// the temperature, between 0.01 and 10.
+func FitTemperature(scores, targets [][]float64) float64 {
+	nll := func(logTemperature float64) float64 {
+		temperature := math.Exp(logTemperature)
+		total := 0.0
+		for i, row := range scores {
+			p := SoftmaxWithTemperature(row, temperature)
+			for k, target := range targets[i] {
+				if target > 0 {
+					total -= target * math.Log(math.Max(p[k], minProbability))
+				}
+			}
+		}
+		return total
+	}
+
+	ratio := (math.Sqrt(5) - 1) / 2
+	a, b := math.Log(minTemperature), math.Log(maxTemperature)
+	c, d := b-ratio*(b-a), a+ratio*(b-a)
+	fc, fd := nll(c), nll(d)
+	for b-a > 1e-3 {
+		if fc < fd {
+			b, d, fd = d, c, fc
+			c = b - ratio*(b-a)
+			fc = nll(c)
+		} else {
+			a, c, fc = c, d, fd
+			d = a + ratio*(b-a)
+			fd = nll(d)
+		}
+	}
+	return math.Exp((a + b) / 2)
+}
+
+// normaliseRecordings standardises the features of every recording with its own baseline scaler.
+func normaliseRecordings(features [][]float64, recordings []int) ([][]float64, error) {
+	samples := map[int][]int{}
+	for i, recording := range recordings {
+		samples[recording] = append(samples[recording], i)
+	}
+
+	scaled := make([][]float64, len(features))
+	for _, indexes := range samples {
+		recording := make([][]float64, len(indexes))
+		for k, i := range indexes {
+			recording[k] = features[i]
+		}
+		scaler, err := FitScaler(recording, ScalerBaseline)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range indexes {
+			scaled[i] = scaler.Transform(features[i])
+		}
+	}
+	return scaled, nil
+}
+
+// ridgeSolve returns the β minimising ‖Hβ − T‖² + λ‖β‖², through the smaller of the two normal
+// equations, or the Moore–Penrose solution H⁺T when λ is 0.
+func ridgeSolve(h, t RealMatrix, lambda float64) (RealMatrix, error) {
+	if lambda == 0 {
+		pinv, err := h.PseudoInverse()
+		if err != nil {
+			return RealMatrix{}, err
+		}
+		return pinv.Mul(t), nil
+	}
+
+	ht := h.Transpose()
+	if h.Rows >= h.Cols {
+		// β = (HᵀH + λI)⁻¹ HᵀT
+		gram := ht.Mul(h)
+		gram.AddDiagonalInPlace(lambda)
+		return gram.SolveSPD(ht.Mul(t))
+	}
+
+	// β = Hᵀ (HHᵀ + λI)⁻¹ T
+	gram := h.Mul(ht)
+	gram.AddDiagonalInPlace(lambda)
+	solved, err := gram.SolveSPD(t)
+	if err != nil {
+		return RealMatrix{}, err
+	}
+	return ht.Mul(solved), nil
+}
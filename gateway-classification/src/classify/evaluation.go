@@ -0,0 +1,175 @@
+package classify
+
+import (
+	"fmt"
+)
+
+// ConfusionMatrix counts the epochs of every reference stage by predicted stage.
+type ConfusionMatrix struct {
+	Labels []string `json:"labels"`
+	// Counts[i][j] is the number of epochs of reference stage Labels[i] predicted as Labels[j].
+	Counts [][]int `json:"counts"`
+	index  map[string]int
+}
+
+// ClassMetrics are the metrics of one stage of a confusion matrix.
+//
+// Precision is 0 when the stage is never predicted, and Recall when it is never in the reference.
+type ClassMetrics struct {
+	Label     string  `json:"label"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	// Support is the number of reference epochs of the stage, and Predicted the number of epochs predicted as it.
+	Support   int `json:"support"`
+	Predicted int `json:"predicted"`
+}
+
+// Evaluation summarises a confusion matrix.
+type Evaluation struct {
+	Epochs    int              `json:"epochs"`
+	Accuracy  float64          `json:"accuracy"`
+	Kappa     float64          `json:"kappa"`
+	Classes   []ClassMetrics   `json:"classes"`
+	Confusion *ConfusionMatrix `json:"confusion"`
+}
+
+// NewConfusionMatrix returns an empty confusion matrix of the labels.
+func NewConfusionMatrix(labels []string) *ConfusionMatrix {
+	m := &ConfusionMatrix{
+		Labels: append([]string(nil), labels...),
+		Counts: make([][]int, len(labels)),
+		index:  make(map[string]int, len(labels)),
+	}
+	for i, label := range labels {
+		m.Counts[i] = make([]int, len(labels))
+		m.index[label] = i
+	}
+	return m
+}
+
+// Add counts an epoch of the reference stage predicted as predicted. It reports false, and counts
+// nothing, when either stage is not a label of the matrix, such as an unscorable epoch.
+func (m *ConfusionMatrix) Add(reference, predicted string) bool {
+	i, ok := m.index[reference]
+	if !ok {
+		return false
+	}
+	j, ok := m.index[predicted]
+	if !ok {
+		return false
+	}
+	m.Counts[i][j]++
+	return true
+}
+
+// Merge adds the counts of other, which must have the same labels.
+func (m *ConfusionMatrix) Merge(other *ConfusionMatrix) error {
+	if !equalStrings(m.Labels, other.Labels) {
+		return fmt.Errorf("confusion matrices of labels %v and %v", m.Labels, other.Labels)
+	}
+	for i := range m.Counts {
+		for j := range m.Counts[i] {
+			m.Counts[i][j] += other.Counts[i][j]
+		}
+	}
+	return nil
+}
+
+// Total returns the number of epochs counted.
+func (m *ConfusionMatrix) Total() int {
+	total := 0
+	for _, row := range m.Counts {
+		for _, n := range row {
+			total += n
+		}
+	}
+	return total
+}
+
+// Accuracy returns the fraction of epochs predicted as their reference stage, 0 when there are none.
+func (m *ConfusionMatrix) Accuracy() float64 {
+	total := m.Total()
+	if total == 0 {
+		return 0
+	}
+	correct := 0
+	for i := range m.Counts {
+		correct += m.Counts[i][i]
+	}
+	return float64(correct) / float64(total)
+}
+
+// Kappa returns Cohen's kappa, the agreement of the predictions with the reference beyond the
+// agreement expected by chance: (po - pe) / (1 - pe). It is 0 when there are no epochs, and when
+// agreement by chance is certain, i.e. both only hold the same single stage.
+func (m *ConfusionMatrix) Kappa() float64 {
+	total := float64(m.Total())
+	if total == 0 {
+		return 0
+	}
+
+	observed, expected := 0.0, 0.0
+	for i := range m.Counts {
+		observed += float64(m.Counts[i][i])
+		reference, predicted := 0, 0
+		for j := range m.Counts {
+			reference += m.Counts[i][j]
+			predicted += m.Counts[j][i]
+		}
+		expected += float64(reference) * float64(predicted)
+	}
+	observed /= total
+	expected /= total * total
+	if expected >= 1 {
+		return 0
+	}
+	return (observed - expected) / (1 - expected)
+}
+
+// Classes returns the precision, recall and F1 score of every label.
+func (m *ConfusionMatrix) Classes() []ClassMetrics {
+	classes := make([]ClassMetrics, len(m.Labels))
+	for i, label := range m.Labels {
+		c := ClassMetrics{Label: label}
+		for j := range m.Counts {
+			c.Support += m.Counts[i][j]
+			c.Predicted += m.Counts[j][i]
+		}
+		correct := float64(m.Counts[i][i])
+		if c.Predicted > 0 {
+			c.Precision = correct / float64(c.Predicted)
+		}
+		if c.Support > 0 {
+			c.Recall = correct / float64(c.Support)
+		}
+		if c.Precision+c.Recall > 0 {
+			c.F1 = 2 * c.Precision * c.Recall / (c.Precision + c.Recall)
+		}
+		classes[i] = c
+	}
+	return classes
+}
+
+// Evaluate returns the accuracy, kappa and class metrics of the matrix.
+func (m *ConfusionMatrix) Evaluate() Evaluation {
+	return Evaluation{
+		Epochs:    m.Total(),
+		Accuracy:  m.Accuracy(),
+		Kappa:     m.Kappa(),
+		Classes:   m.Classes(),
+		Confusion: m,
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package classify
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadLabeledFeatures reads feature vectors from CSV, one sample per row with its label in the last column.
+// A first row whose first field is not a number is a header and is skipped.
+func ReadLabeledFeatures(r io.Reader) ([][]float64, []string, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) > 0 {
+		if _, err := strconv.ParseFloat(strings.TrimSpace(records[0][0]), 64); err != nil {
+			records = records[1:]
+		}
+	}
+
+	features := make([][]float64, 0, len(records))
+	labels := make([]string, 0, len(records))
+	for i, record := range records {
+		if len(record) < 2 {
+			return nil, nil, fmt.Errorf("row %d: expected features and a label", i+1)
+		}
+
+		x := make([]float64, len(record)-1)
+		for j, field := range record[:len(record)-1] {
+			x[j], err = strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("row %d, column %d: %w", i+1, j+1, err)
+			}
+		}
+		features = append(features, x)
+		labels = append(labels, strings.TrimSpace(record[len(record)-1]))
+	}
+	return features, labels, nil
+}
+
+// WriteLabeledFeatures writes feature vectors as CSV with a header of the given column names, the label last.
+func WriteLabeledFeatures(w io.Writer, header []string, features [][]float64, labels []string) error {
+	writer := csv.NewWriter(w)
+	if header != nil {
+		if err := writer.Write(append(append([]string(nil), header...), "label")); err != nil {
+			return err
+		}
+	}
+
+	for i, x := range features {
+		record := make([]string, 0, len(x)+1)
+		for _, v := range x {
+			record = append(record, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		record = append(record, labels[i])
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,130 @@
+package classify
+
+import (
+	"math"
+	"math/bits"
+	"math/cmplx"
+)
+
+// FFT computes the discrete Fourier transform of x.
+//
+// Power-of-two lengths use an iterative radix-2 FFT, other lengths use Bluestein's algorithm on top
+// of it, so any length is supported. x is not modified.
+func FFT(x []complex128) []complex128 {
+	y := make([]complex128, len(x))
+	copy(y, x)
+
+	switch n := len(y); {
+	case n <= 1:
+		return y
+	case n&(n-1) == 0:
+		radix2(y, false)
+		return y
+	default:
+		return bluestein(y)
+	}
+}
+
+// IFFT computes the inverse discrete Fourier transform of x, scaled by 1/n.
+func IFFT(x []complex128) []complex128 {
+	n := len(x)
+	conj := make([]complex128, n)
+	for i, v := range x {
+		conj[i] = cmplx.Conj(v)
+	}
+
+	y := FFT(conj)
+	for i, v := range y {
+		y[i] = cmplx.Conj(v) / complex(float64(n), 0)
+	}
+	return y
+}
+
+// RFFT computes the discrete Fourier transform of the real signal x and returns its n/2+1
+// non-negative frequency bins; the others are their complex conjugates.
+func RFFT(x []float64) []complex128 {
+	if len(x) == 0 {
+		return nil
+	}
+
+	buffer := make([]complex128, len(x))
+	for i, v := range x {
+		buffer[i] = complex(v, 0)
+	}
+	return FFT(buffer)[:len(x)/2+1]
+}
+
+// NextPowerOfTwo returns the smallest power of two not below n.
+func NextPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// radix2 transforms x in place. Its length must be a power of two.
+func radix2(x []complex128, inverse bool) {
+	n := len(x)
+	shift := 64 - bits.Len(uint(n-1))
+	for i := range x {
+		j := int(bits.Reverse64(uint64(i)) >> shift)
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1.0
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		half := size / 2
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < half; k++ {
+				even, odd := x[start+k], w*x[start+k+half]
+				x[start+k] = even + odd
+				x[start+k+half] = even - odd
+				w *= step
+			}
+		}
+	}
+}
+
+// bluestein computes the FFT of x, of any length, as a convolution with a chirp done by radix-2 FFTs.
+func bluestein(x []complex128) []complex128 {
+	n := len(x)
+	m := NextPowerOfTwo(2*n - 1)
+
+	// k² is reduced modulo 2n so the chirp angle stays accurate for long inputs.
+	chirp := make([]complex128, n)
+	for k := range chirp {
+		k2 := (k * k) % (2 * n)
+		chirp[k] = cmplx.Rect(1, -math.Pi*float64(k2)/float64(n))
+	}
+
+	a := make([]complex128, m)
+	b := make([]complex128, m)
+	for k := 0; k < n; k++ {
+		a[k] = x[k] * chirp[k]
+		b[k] = cmplx.Conj(chirp[k])
+		if k > 0 {
+			b[m-k] = b[k]
+		}
+	}
+
+	radix2(a, false)
+	radix2(b, false)
+	for i := range a {
+		a[i] *= b[i]
+	}
+	radix2(a, true)
+
+	y := make([]complex128, n)
+	for k := range y {
+		y[k] = a[k] / complex(float64(m), 0) * chirp[k]
+	}
+	return y
+}
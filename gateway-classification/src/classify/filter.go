@@ -0,0 +1,333 @@
+package classify
+
+import (
+	"math"
+)
+
+// Filters for ECG preprocessing.
+//
+// Every filter can be applied causally, sample by sample for streaming data, or forwards and
+// backwards with FiltFilt for offline windows, which cancels the phase delay and squares the magnitude response.
+
+// LinearFilter is a linear time-invariant filter designed for a sampling rate.
+type LinearFilter interface {
+	// Filter applies the filter causally to x.
+	Filter(x []float64) []float64
+	// FiltFilt applies the filter forwards and backwards to x, so the output has no phase delay.
+	FiltFilt(x []float64) []float64
+	// Stream returns a causal filter processing one sample at a time.
+	Stream() StreamFilter
+}
+
+// StreamFilter filters a signal one sample at a time.
+type StreamFilter interface {
+	// Next filters the next sample.
+	Next(x float64) float64
+	// Reset clears the state, as before the first sample.
+	Reset()
+}
+
+// Biquad is a second order IIR section with coefficients normalised by a0.
+type Biquad struct {
+	B0, B1, B2 float64
+	A1, A2     float64
+}
+
+// butterworthQ is the quality factor of a second order Butterworth section.
+const butterworthQ = 1 / math.Sqrt2
+
+// DefaultNotchQ is the quality factor of the powerline notch: a 50 Hz notch is about 1.7 Hz wide.
+const DefaultNotchQ = 30
+
+// LowPassBiquad returns a second order low-pass section, Butterworth for q = 1/√2.
+func LowPassBiquad(fs, cutoff, q float64) Biquad {
+	cos, alpha := biquadParams(fs, cutoff, q)
+	return normalise(Biquad{B0: (1 - cos) / 2, B1: 1 - cos, B2: (1 - cos) / 2, A1: -2 * cos, A2: 1 - alpha}, 1+alpha)
+}
+
+// HighPassBiquad returns a second order high-pass section, Butterworth for q = 1/√2.
+func HighPassBiquad(fs, cutoff, q float64) Biquad {
+	cos, alpha := biquadParams(fs, cutoff, q)
+	return normalise(Biquad{B0: (1 + cos) / 2, B1: -(1 + cos), B2: (1 + cos) / 2, A1: -2 * cos, A2: 1 - alpha}, 1+alpha)
+}
+
+// NotchBiquad returns a notch section rejecting frequency, with the given quality factor.
+func NotchBiquad(fs, frequency, q float64) Biquad {
+	cos, alpha := biquadParams(fs, frequency, q)
+	return normalise(Biquad{B0: 1, B1: -2 * cos, B2: 1, A1: -2 * cos, A2: 1 - alpha}, 1+alpha)
+}
+
+// biquadParams returns cos(w0) and alpha of the bilinear-transform designs of the Audio EQ Cookbook.
+func biquadParams(fs, frequency, q float64) (float64, float64) {
+	w0 := 2 * math.Pi * frequency / fs
+	return math.Cos(w0), math.Sin(w0) / (2 * q)
+}
+
+func normalise(b Biquad, a0 float64) Biquad {
+	return Biquad{B0: b.B0 / a0, B1: b.B1 / a0, B2: b.B2 / a0, A1: b.A1 / a0, A2: b.A2 / a0}
+}
+
+// IIRFilter is a cascade of biquad sections.
+type IIRFilter struct {
+	Sections []Biquad
+}
+
+// NewHighPassFilter returns a Butterworth high-pass filter, e.g. to remove baseline wander.
+// Odd orders are rounded up to the next even order.
+func NewHighPassFilter(fs, cutoff float64, order int) *IIRFilter {
+	filter := &IIRFilter{}
+	for _, q := range butterworthQs(order) {
+		filter.Sections = append(filter.Sections, HighPassBiquad(fs, cutoff, q))
+	}
+	return filter
+}
+
+// NewLowPassFilter returns a Butterworth low-pass filter. Odd orders are rounded up to the next even order.
+func NewLowPassFilter(fs, cutoff float64, order int) *IIRFilter {
+	filter := &IIRFilter{}
+	for _, q := range butterworthQs(order) {
+		filter.Sections = append(filter.Sections, LowPassBiquad(fs, cutoff, q))
+	}
+	return filter
+}
+
+// NewBandPassFilter returns the cascade of a second order high-pass at low and a second order low-pass at high.
+// The low-pass is left out when high is not below the Nyquist frequency.
+func NewBandPassFilter(fs, low, high float64) *IIRFilter {
+	filter := &IIRFilter{Sections: []Biquad{HighPassBiquad(fs, low, butterworthQ)}}
+	if high < fs/2 {
+		filter.Sections = append(filter.Sections, LowPassBiquad(fs, high, butterworthQ))
+	}
+	return filter
+}
+
+// NewNotchFilter returns a notch filter rejecting frequency, such as the 50 or 60 Hz powerline.
+func NewNotchFilter(fs, frequency, q float64) *IIRFilter {
+	return &IIRFilter{Sections: []Biquad{NotchBiquad(fs, frequency, q)}}
+}
+
+// butterworthQs returns the quality factors of the biquads of a Butterworth filter of the given order,
+// one per pair of conjugate poles.
+func butterworthQs(order int) []float64 {
+	sections := (order + 1) / 2
+	if sections < 1 {
+		sections = 1
+	}
+	n := float64(2 * sections)
+
+	qs := make([]float64, sections)
+	for k := range qs {
+		qs[k] = 1 / (2 * math.Sin(float64(2*k+1)*math.Pi/(2*n)))
+	}
+	return qs
+}
+
+func (f *IIRFilter) Filter(x []float64) []float64 {
+	return filterStream(f.Stream(), x)
+}
+
+func (f *IIRFilter) FiltFilt(x []float64) []float64 {
+	return filtFilt(f, x, 3*(2*len(f.Sections)+1))
+}
+
+func (f *IIRFilter) Stream() StreamFilter {
+	return &iirStream{sections: f.Sections, state: make([][4]float64, len(f.Sections))}
+}
+
+type iirStream struct {
+	sections []Biquad
+	// state holds x[n-1], x[n-2], y[n-1] and y[n-2] of every section.
+	state [][4]float64
+}
+
+func (s *iirStream) Next(x float64) float64 {
+	for i, b := range s.sections {
+		st := &s.state[i]
+		y := b.B0*x + b.B1*st[0] + b.B2*st[1] - b.A1*st[2] - b.A2*st[3]
+		st[1], st[0] = st[0], x
+		st[3], st[2] = st[2], y
+		x = y
+	}
+	return x
+}
+
+func (s *iirStream) Reset() {
+	for i := range s.state {
+		s.state[i] = [4]float64{}
+	}
+}
+
+// FIRFilter is a finite impulse response filter.
+type FIRFilter struct {
+	Taps []float64
+}
+
+// NewFIRHighPass returns a windowed-sinc high-pass filter with an odd number of taps, made by spectral
+// inversion of a Hamming-windowed low-pass. Its delay is (taps-1)/2 samples when applied causally.
+func NewFIRHighPass(fs, cutoff float64, taps int) *FIRFilter {
+	if taps%2 == 0 {
+		taps++
+	}
+	window := HammingWindow(taps)
+	middle := taps / 2
+	fc := cutoff / fs
+
+	lowPass := make([]float64, taps)
+	total := 0.0
+	for i := range lowPass {
+		n := float64(i - middle)
+		if n == 0 {
+			lowPass[i] = 2 * fc
+		} else {
+			lowPass[i] = math.Sin(2*math.Pi*fc*n) / (math.Pi * n)
+		}
+		lowPass[i] *= window[i]
+		total += lowPass[i]
+	}
+
+	highPass := make([]float64, taps)
+	for i, v := range lowPass {
+		highPass[i] = -v / total
+	}
+	highPass[middle]++
+	return &FIRFilter{Taps: highPass}
+}
+
+func (f *FIRFilter) Filter(x []float64) []float64 {
+	return filterStream(f.Stream(), x)
+}
+
+func (f *FIRFilter) FiltFilt(x []float64) []float64 {
+	return filtFilt(f, x, 3*len(f.Taps))
+}
+
+func (f *FIRFilter) Stream() StreamFilter {
+	return &firStream{taps: f.Taps, history: make([]float64, len(f.Taps))}
+}
+
+type firStream struct {
+	taps []float64
+	// history is a ring buffer of the last len(taps) inputs, the newest at next-1.
+	history []float64
+	next    int
+}
+
+func (s *firStream) Next(x float64) float64 {
+	if len(s.taps) == 0 {
+		return x
+	}
+	s.history[s.next] = x
+	y := 0.0
+	j := s.next
+	for _, tap := range s.taps {
+		y += tap * s.history[j]
+		if j--; j < 0 {
+			j = len(s.history) - 1
+		}
+	}
+	if s.next++; s.next == len(s.history) {
+		s.next = 0
+	}
+	return y
+}
+
+func (s *firStream) Reset() {
+	for i := range s.history {
+		s.history[i] = 0
+	}
+	s.next = 0
+}
+
+// FilterChain applies several filters one after the other.
+type FilterChain []LinearFilter
+
+// NewECGFilter returns the preprocessing chain of ECG windows: a 0.5 Hz high-pass for baseline wander,
+// a notch at the powerline frequency and a 40 Hz low-pass. A powerline of 0, or one not below the
+// Nyquist frequency, leaves the notch out, as does a sampling rate too low for the low-pass.
+func NewECGFilter(fs, powerline float64) FilterChain {
+	chain := FilterChain{NewHighPassFilter(fs, 0.5, 2)}
+	if powerline > 0 && powerline < fs/2 {
+		chain = append(chain, NewNotchFilter(fs, powerline, DefaultNotchQ))
+	}
+	if 40 < fs/2 {
+		chain = append(chain, NewLowPassFilter(fs, 40, 2))
+	}
+	return chain
+}
+
+func (c FilterChain) Filter(x []float64) []float64 {
+	for _, f := range c {
+		x = f.Filter(x)
+	}
+	return x
+}
+
+func (c FilterChain) FiltFilt(x []float64) []float64 {
+	for _, f := range c {
+		x = f.FiltFilt(x)
+	}
+	return x
+}
+
+func (c FilterChain) Stream() StreamFilter {
+	streams := make(chainStream, len(c))
+	for i, f := range c {
+		streams[i] = f.Stream()
+	}
+	return streams
+}
+
+type chainStream []StreamFilter
+
+func (s chainStream) Next(x float64) float64 {
+	for _, f := range s {
+		x = f.Next(x)
+	}
+	return x
+}
+
+func (s chainStream) Reset() {
+	for _, f := range s {
+		f.Reset()
+	}
+}
+
+func filterStream(s StreamFilter, x []float64) []float64 {
+	y := make([]float64, len(x))
+	for i, v := range x {
+		y[i] = s.Next(v)
+	}
+	return y
+}
+
+// filtFilt filters x forwards and backwards. x is first extended at both ends by an odd reflection
+// of padding samples, so the filter starts on a continuation of the signal rather than a step.
+func filtFilt(f LinearFilter, x []float64, padding int) []float64 {
+	if len(x) == 0 {
+		return nil
+	}
+	if padding > len(x)-1 {
+		padding = len(x) - 1
+	}
+
+	n := len(x)
+	padded := make([]float64, 0, n+2*padding)
+	for i := padding; i > 0; i-- {
+		padded = append(padded, 2*x[0]-x[i])
+	}
+	padded = append(padded, x...)
+	for i := 1; i <= padding; i++ {
+		padded = append(padded, 2*x[n-1]-x[n-1-i])
+	}
+
+	y := reverse(f.Filter(reverse(f.Filter(padded))))
+	return y[padding : padding+n]
+}
+
+func reverse(x []float64) []float64 {
+	y := make([]float64, len(x))
+	for i, v := range x {
+		y[len(x)-1-i] = v
+	}
+	return y
+}
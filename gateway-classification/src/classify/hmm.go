@@ -0,0 +1,277 @@
+package classify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+// TransitionMatrix is the hidden Markov model of a hypnogram: the probability of every stage
+// following every other one between consecutive epochs, and of the first epoch's stage.
+type TransitionMatrix struct {
+	Labels []string `json:"labels"`
+	// Transitions[i][j] is the probability of Labels[j] following Labels[i]. Rows sum to 1.
+	Transitions [][]float64 `json:"transitions"`
+	// Initial[i] is the probability of the first epoch being Labels[i].
+	Initial []float64 `json:"initial"`
+}
+
+// DefaultStayProbability is the probability of a stage lasting another epoch in NewStickyTransitions.
+const DefaultStayProbability = 0.9
+
+// NewStickyTransitions returns a matrix where every stage lasts another epoch with probability stay
+// and changes to any other stage with equal probability.
+func NewStickyTransitions(labels []string, stay float64) *TransitionMatrix {
+	n := len(labels)
+	tm := &TransitionMatrix{
+		Labels:      labels,
+		Transitions: make([][]float64, n),
+		Initial:     make([]float64, n),
+	}
+	for i := range tm.Transitions {
+		tm.Transitions[i] = make([]float64, n)
+		for j := range tm.Transitions[i] {
+			if i == j || n == 1 {
+				tm.Transitions[i][j] = stay
+			} else {
+				tm.Transitions[i][j] = (1 - stay) / float64(n-1)
+			}
+		}
+		tm.Initial[i] = 1 / float64(n)
+	}
+	tm.normalise()
+	return tm
+}
+
+// LearnTransitions estimates the matrix from labeled hypnograms, one stage per epoch.
+//
+// Every transition is counted, plus smoothing so that transitions never seen keep a small probability.
+// Stages that are not in labels, such as unscorable epochs, break the sequence.
+func LearnTransitions(labels []string, hypnograms [][]string, smoothing float64) (*TransitionMatrix, error) {
+	index := labelIndex(labels)
+	n := len(labels)
+
+	tm := &TransitionMatrix{
+		Labels:      labels,
+		Transitions: make([][]float64, n),
+		Initial:     make([]float64, n),
+	}
+	for i := range tm.Transitions {
+		tm.Transitions[i] = make([]float64, n)
+		for j := range tm.Transitions[i] {
+			tm.Transitions[i][j] = smoothing
+		}
+		tm.Initial[i] = smoothing
+	}
+
+	transitions := 0
+	for _, hypnogram := range hypnograms {
+		previous := -1
+		for t, stage := range hypnogram {
+			current, ok := index[stage]
+			if !ok {
+				previous = -1
+				continue
+			}
+			if t == 0 {
+				tm.Initial[current]++
+			}
+			if previous >= 0 {
+				tm.Transitions[previous][current]++
+				transitions++
+			}
+			previous = current
+		}
+	}
+	if transitions == 0 && smoothing <= 0 {
+		return nil, errors.New("no stage transitions in the hypnograms")
+	}
+
+	tm.normalise()
+	return tm, nil
+}
+
+// LoadTransitionMatrix reads a matrix from a JSON file and validates it.
+func LoadTransitionMatrix(path string) (*TransitionMatrix, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tm TransitionMatrix
+	if err := json.Unmarshal(data, &tm); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	if len(tm.Initial) == 0 {
+		tm.Initial = make([]float64, len(tm.Labels))
+		for i := range tm.Initial {
+			tm.Initial[i] = 1
+		}
+	}
+	if err := tm.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	tm.normalise()
+	return &tm, nil
+}
+
+// Save writes the matrix to a JSON file.
+func (tm *TransitionMatrix) Save(path string) error {
+	data, err := json.MarshalIndent(tm, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// Validate checks that the matrix is square over its labels and holds no negative probabilities.
+func (tm *TransitionMatrix) Validate() error {
+	n := len(tm.Labels)
+	if n == 0 {
+		return errors.New("transition matrix has no labels")
+	}
+	if len(tm.Transitions) != n || len(tm.Initial) != n {
+		return fmt.Errorf("transition matrix is not %dx%d", n, n)
+	}
+	for i, row := range tm.Transitions {
+		if len(row) != n {
+			return fmt.Errorf("transition matrix row %d has %d columns, expected %d", i, len(row), n)
+		}
+		if sum(row) <= 0 {
+			return fmt.Errorf("transition matrix row %d is empty", i)
+		}
+		for _, p := range row {
+			if p < 0 || math.IsNaN(p) {
+				return fmt.Errorf("transition matrix row %d has invalid probability %g", i, p)
+			}
+		}
+	}
+	for _, p := range tm.Initial {
+		if p < 0 || math.IsNaN(p) {
+			return fmt.Errorf("invalid initial probability %g", p)
+		}
+	}
+	return nil
+}
+
+// normalise scales the rows and the initial probabilities to sum to 1.
+func (tm *TransitionMatrix) normalise() {
+	for _, row := range tm.Transitions {
+		normaliseProbabilities(row)
+	}
+	normaliseProbabilities(tm.Initial)
+}
+
+// Viterbi returns the most likely stage of every epoch given the probabilities of every stage per epoch,
+// in the order of tm.Labels. Epochs without probabilities, such as unscorable ones, do not constrain
+// the stage, which is only inferred from the epochs around them.
+func (tm *TransitionMatrix) Viterbi(probabilities [][]float64) ([]string, error) {
+	n := len(tm.Labels)
+	epochs := len(probabilities)
+	if epochs == 0 {
+		return nil, nil
+	}
+	for t, p := range probabilities {
+		if p != nil && len(p) != n {
+			return nil, fmt.Errorf("epoch %d has %d probabilities, expected %d", t, len(p), n)
+		}
+	}
+
+	logTransitions := make([][]float64, n)
+	for i, row := range tm.Transitions {
+		logTransitions[i] = logProbabilities(row)
+	}
+	emission := func(t int) []float64 {
+		if probabilities[t] == nil {
+			return make([]float64, n)
+		}
+		return logProbabilities(probabilities[t])
+	}
+
+	score := logProbabilities(tm.Initial)
+	for i, e := range emission(0) {
+		score[i] += e
+	}
+
+	backpointers := make([][]int, epochs)
+	for t := 1; t < epochs; t++ {
+		next := make([]float64, n)
+		backpointers[t] = make([]int, n)
+		e := emission(t)
+		for j := 0; j < n; j++ {
+			best := 0
+			for i := 1; i < n; i++ {
+				if score[i]+logTransitions[i][j] > score[best]+logTransitions[best][j] {
+					best = i
+				}
+			}
+			next[j] = score[best] + logTransitions[best][j] + e[j]
+			backpointers[t][j] = best
+		}
+		score = next
+	}
+
+	state := 0
+	for i := range score {
+		if score[i] > score[state] {
+			state = i
+		}
+	}
+
+	path := make([]string, epochs)
+	for t := epochs - 1; t >= 0; t-- {
+		path[t] = tm.Labels[state]
+		if t > 0 {
+			state = backpointers[t][state]
+		}
+	}
+	return path, nil
+}
+
+// SoftmaxWithTemperature turns classifier outputs into probabilities, dividing them by the temperature
+// first. A lower temperature makes the probabilities sharper.
+func SoftmaxWithTemperature(scores []float64, temperature float64) []float64 {
+	probabilities := make([]float64, len(scores))
+	if len(scores) == 0 {
+		return probabilities
+	}
+
+	largest := max(scores)
+	for i, score := range scores {
+		probabilities[i] = math.Exp((score - largest) / temperature)
+	}
+	normaliseProbabilities(probabilities)
+	return probabilities
+}
+
+// minProbability keeps the log of impossible events finite, so that Viterbi always finds a path.
+const minProbability = 1e-12
+
+func logProbabilities(p []float64) []float64 {
+	logs := make([]float64, len(p))
+	for i, v := range p {
+		logs[i] = math.Log(math.Max(v, minProbability))
+	}
+	return logs
+}
+
+func normaliseProbabilities(p []float64) {
+	total := sum(p)
+	if total <= 0 {
+		return
+	}
+	for i := range p {
+		p[i] /= total
+	}
+}
+
+func labelIndex(labels []string) map[string]int {
+	index := make(map[string]int, len(labels))
+	for i, label := range labels {
+		index[label] = i
+	}
+	return index
+}
@@ -24,9 +24,20 @@ type HRVFeature struct {
 	F16_VLF                float64
 	F17_LF                 float64
 	F18_HF                 float64
+
+	// Complexity features, only in the ExtendedVector. 0 when the series is too short.
+	F19_SampEn float64
+	F20_ApEn   float64
+	F21_DFA1   float64
+	F22_DFA2   float64
 }
 
 func NewHRVFeature(rrIntervalSet RRIntervalSet) *HRVFeature {
+	return NewHRVFeatureWithOptions(rrIntervalSet, DefaultNonlinearOptions)
+}
+
+// NewHRVFeatureWithOptions computes the features, with the given options for the complexity features.
+func NewHRVFeatureWithOptions(rrIntervalSet RRIntervalSet, options NonlinearOptions) *HRVFeature {
 	var hrv HRVFeature
 
 	rrIntervalValue := rrIntervalSet.RRIntervalValue
@@ -53,9 +64,65 @@ func NewHRVFeature(rrIntervalSet RRIntervalSet) *HRVFeature {
 	hrv.F17_LF = feature12To18.LF
 	hrv.F18_HF = feature12To18.HF
 
+	r := options.Tolerance * sampleStandardDeviation(rrIntervalValue)
+	hrv.F19_SampEn = finiteOrZero(SampleEntropy(rrIntervalValue, options.EmbeddingDimension, r))
+	hrv.F20_ApEn = finiteOrZero(ApproximateEntropy(rrIntervalValue, options.EmbeddingDimension, r))
+	hrv.F21_DFA1 = finiteOrZero(DFA(rrIntervalValue, options.ShortScales[0], options.ShortScales[1]))
+	hrv.F22_DFA2 = finiteOrZero(DFA(rrIntervalValue, options.LongScales[0], options.LongScales[1]))
+
 	return &hrv
 }
 
+// Vector returns the features in the F01..F18 order the models are trained on.
+func (hrv *HRVFeature) Vector() []float64 {
+	return []float64{
+		hrv.F01_AVNN,
+		hrv.F02_SDNN,
+		hrv.F03_RMSSD,
+		hrv.F04_SDSD,
+		hrv.F05_NNx,
+		hrv.F06_PNNx,
+		hrv.F07_HRV_TRIANGULAR_IDX,
+		hrv.F08_SD1,
+		hrv.F09_SD2,
+		hrv.F10_SD1_SD2_RATIO,
+		hrv.F11_S,
+		hrv.F12_TP,
+		hrv.F13_pLF,
+		hrv.F14_pHF,
+		hrv.F15_LFHFratio,
+		hrv.F16_VLF,
+		hrv.F17_LF,
+		hrv.F18_HF,
+	}
+}
+
+// HRVFeatureNames are the names of the features of ExtendedVector, in order. The first FeatureCount are those of Vector.
+var HRVFeatureNames = []string{
+	"F01_AVNN",
+	"F02_SDNN",
+	"F03_RMSSD",
+	"F04_SDSD",
+	"F05_NNx",
+	"F06_PNNx",
+	"F07_HRV_TRIANGULAR_IDX",
+	"F08_SD1",
+	"F09_SD2",
+	"F10_SD1_SD2_RATIO",
+	"F11_S",
+	"F12_TP",
+	"F13_pLF",
+	"F14_pHF",
+	"F15_LFHFratio",
+	"F16_VLF",
+	"F17_LF",
+	"F18_HF",
+	"F19_SampEn",
+	"F20_ApEn",
+	"F21_DFA1",
+	"F22_DFA2",
+}
+
 func f01_AVNN(rrIntervalValue []float64) float64 {
 	return mean(rrIntervalValue)
 }
@@ -131,9 +198,38 @@ func f11_S(sd1, sd2 float64) float64 {
 	return math.Pi * sd1 * sd2
 }
 
+// f12_18 computes the frequency-domain features of the RR intervals, in seconds.
+//
+// The RR series, in ms, is resampled at Fs Hz, detrended, and its Welch PSD is integrated over the
+// VLF (0.003–0.04 Hz), LF (0.04–0.15 Hz) and HF (0.15–0.4 Hz) bands. TP is VLF+LF+HF, pLF and pHF are
+// LF and HF in percent of LF+HF. Too short series give all-zero features.
 func f12_18(rrIntervalValue []float64, Fs float64) Feature12To18 {
 	var feature Feature12To18
-	// Implement the logic for feature calculation
+
+	YY, f := WelchPSD(detrend(resampleRR(mulList(rrIntervalValue, 1000), Fs)), Fs, WelchOptions{})
+	if len(YY) < 2 {
+		return feature
+	}
+	df := f[1] - f[0]
+
+	bandPower := func(low, high float64) float64 {
+		return sum(filterF(YY, f, func(x float64) bool {
+			return x >= low && x < high
+		})) * df
+	}
+
+	feature.VLF = bandPower(0.003, 0.04)
+	feature.LF = bandPower(0.04, 0.15)
+	feature.HF = bandPower(0.15, 0.4)
+	feature.TP = feature.VLF + feature.LF + feature.HF
+
+	if feature.LF+feature.HF > 0 {
+		feature.pLF = feature.LF / (feature.LF + feature.HF) * 100
+		feature.pHF = feature.HF / (feature.LF + feature.HF) * 100
+	}
+	if feature.HF > 0 {
+		feature.LFHFratio = feature.LF / feature.HF
+	}
 	return feature
 }
 
@@ -158,12 +254,34 @@ func nanzscore(input []float64) []float64 {
 	return z
 }
 
+// nanmean is the mean of the values of input that are not NaN.
 func nanmean(input []float64) float64 {
-	return mean(input)
+	return mean(withoutNaN(input))
 }
 
+// nanstd is the population standard deviation of the values of input that are not NaN.
 func nanstd(input []float64) float64 {
-	return populationStandardDeviation(input)
+	return populationStandardDeviation(withoutNaN(input))
+}
+
+// nanmin is the smallest value of input that is not NaN.
+func nanmin(input []float64) float64 {
+	return min(withoutNaN(input))
+}
+
+// nanmax is the largest value of input that is not NaN.
+func nanmax(input []float64) float64 {
+	return max(withoutNaN(input))
+}
+
+func withoutNaN(input []float64) []float64 {
+	var result []float64
+	for _, val := range input {
+		if !math.IsNaN(val) {
+			result = append(result, val)
+		}
+	}
+	return result
 }
 
 type Feature12To18 struct {
@@ -0,0 +1,249 @@
+package classify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// Model bundle format.
+//
+// A bundle is a JSON file holding everything needed to run an ELM: the weights, the activation, the
+// labels of the output nodes, the names of the input features in order, the scaler fitted on the
+// training features and applied before every forward pass, the softmax temperature of the outputs,
+// and metadata. Its checksum is the SHA-256 of the bundle encoded with an empty checksum, so a
+// truncated or edited file is rejected when it is loaded.
+
+const (
+	// ModelBundleFormat identifies model bundle files.
+	ModelBundleFormat = "elm-model-bundle"
+	// ModelBundleVersion is the version of the bundle format written by this package.
+	// Version 2 added min-max and baseline scalers, version 3 the softmax temperature.
+	ModelBundleVersion = 3
+)
+
+// ModelBundle is a self-describing, versioned ELM model.
+type ModelBundle struct {
+	Format        string `json:"format"`
+	FormatVersion int    `json:"format_version"`
+
+	// Version identifies the trained model, e.g. the cohort and date it was trained on.
+	Version     string    `json:"version"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	Activation string   `json:"activation"`
+	Labels     []string `json:"labels"`
+	// Features are the names of the input features, in order. They are a prefix of HRVFeatureNames.
+	Features []string `json:"features"`
+	Scaler   *Scaler  `json:"scaler,omitempty"`
+	// Temperature is the softmax temperature of the outputs, 0 for DefaultTemperature.
+	Temperature float64 `json:"temperature,omitempty"`
+
+	// InputWeight is hidden × features, Bias has one value per hidden node and OutputWeight is hidden × labels.
+	InputWeight  [][]float64 `json:"input_weight"`
+	Bias         []float64   `json:"bias"`
+	OutputWeight [][]float64 `json:"output_weight"`
+
+	Checksum string `json:"checksum"`
+}
+
+// NewModelBundle creates the bundle of a classifier. The feature names are taken from HRVFeatureNames.
+func NewModelBundle(classifier *ELMClassifier, activation, version, description string) (*ModelBundle, error) {
+	features := classifier.Features()
+	if features > len(HRVFeatureNames) {
+		return nil, fmt.Errorf("classifier has %d features, only %d are known", features, len(HRVFeatureNames))
+	}
+
+	model := classifier.Model
+	bias := make([]float64, model.BiasInputWeight.Rows)
+	for i, row := range model.BiasInputWeight.Data {
+		bias[i] = row[0]
+	}
+
+	outputWeight := model.OutputWeight.Data
+	if model.OutputWeight.Rows != model.InputWeight.Rows {
+		outputWeight = model.OutputWeight.Transpose().Data
+	}
+
+	bundle := &ModelBundle{
+		Format:        ModelBundleFormat,
+		FormatVersion: ModelBundleVersion,
+		Version:       version,
+		Description:   description,
+		CreatedAt:     time.Now().UTC(),
+		Activation:    activation,
+		Labels:        classifier.Labels,
+		Features:      HRVFeatureNames[:features],
+		Scaler:        classifier.Scaler,
+		Temperature:   classifier.Temperature,
+		InputWeight:   model.InputWeight.Data,
+		Bias:          bias,
+		OutputWeight:  outputWeight,
+	}
+	if err := bundle.Validate(); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// LoadModelBundle reads a bundle and checks its checksum and contents.
+func LoadModelBundle(path string) (*ModelBundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundle ModelBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+
+	checksum, err := bundle.computeChecksum()
+	if err != nil {
+		return nil, err
+	}
+	if bundle.Checksum != checksum {
+		return nil, fmt.Errorf("%s: checksum mismatch, the bundle is corrupted", path)
+	}
+
+	if err := bundle.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &bundle, nil
+}
+
+// Save sets the checksum of the bundle and writes it to a file.
+func (b *ModelBundle) Save(path string) error {
+	checksum, err := b.computeChecksum()
+	if err != nil {
+		return err
+	}
+	b.Checksum = checksum
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// computeChecksum returns the SHA-256 of the bundle encoded with an empty checksum.
+func (b *ModelBundle) computeChecksum() (string, error) {
+	unsigned := *b
+	unsigned.Checksum = ""
+
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Validate checks the format, the dimensions of the weights and scaler, and that the features are
+// those HRVFeature computes, in its order.
+func (b *ModelBundle) Validate() error {
+	if b.Format != ModelBundleFormat {
+		return fmt.Errorf("unknown model format %q", b.Format)
+	}
+	if b.FormatVersion < 1 || b.FormatVersion > ModelBundleVersion {
+		return fmt.Errorf("unsupported model format version %d", b.FormatVersion)
+	}
+	if _, err := ParseActivation(b.Activation); err != nil {
+		return err
+	}
+
+	if len(b.Labels) == 0 {
+		return errors.New("model has no labels")
+	}
+	seen := map[string]bool{}
+	for _, label := range b.Labels {
+		if label == "" || seen[label] {
+			return fmt.Errorf("invalid or duplicate label %q", label)
+		}
+		seen[label] = true
+	}
+
+	if len(b.Features) != FeatureCount && len(b.Features) != ExtendedFeatureCount {
+		return fmt.Errorf("model has %d features, expected %d or %d", len(b.Features), FeatureCount, ExtendedFeatureCount)
+	}
+	for j, name := range b.Features {
+		if name != HRVFeatureNames[j] {
+			return fmt.Errorf("feature %d is %s, expected %s", j+1, name, HRVFeatureNames[j])
+		}
+	}
+
+	hidden := len(b.InputWeight)
+	if hidden == 0 {
+		return errors.New("model has no hidden nodes")
+	}
+	if err := checkMatrix("input weight", b.InputWeight, hidden, len(b.Features)); err != nil {
+		return err
+	}
+	if len(b.Bias) != hidden {
+		return fmt.Errorf("model has %d biases for %d hidden nodes", len(b.Bias), hidden)
+	}
+	if err := checkMatrix("bias", [][]float64{b.Bias}, 1, hidden); err != nil {
+		return err
+	}
+	if err := checkMatrix("output weight", b.OutputWeight, hidden, len(b.Labels)); err != nil {
+		return err
+	}
+
+	if b.Scaler != nil {
+		if err := b.Scaler.Validate(len(b.Features)); err != nil {
+			return err
+		}
+	}
+	if b.Temperature < 0 || math.IsNaN(b.Temperature) || math.IsInf(b.Temperature, 0) {
+		return fmt.Errorf("invalid temperature %g", b.Temperature)
+	}
+	return nil
+}
+
+// checkMatrix checks that a matrix is rows × cols and holds only finite values.
+func checkMatrix(name string, m [][]float64, rows, cols int) error {
+	if len(m) != rows {
+		return fmt.Errorf("%s has %d rows, expected %d", name, len(m), rows)
+	}
+	for i, row := range m {
+		if len(row) != cols {
+			return fmt.Errorf("%s row %d has %d columns, expected %d", name, i+1, len(row), cols)
+		}
+		for j, v := range row {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("%s[%d][%d] is %g", name, i+1, j+1, v)
+			}
+		}
+	}
+	return nil
+}
+
+// Classifier returns the ELM classifier of the bundle.
+func (b *ModelBundle) Classifier() (*ELMClassifier, error) {
+	hidden := len(b.InputWeight)
+	bias := make([][]float64, hidden)
+	for i, v := range b.Bias {
+		bias[i] = []float64{v}
+	}
+
+	model := &ELMModel{
+		InputWeight:     RealMatrix{Rows: hidden, Cols: len(b.Features), Data: b.InputWeight},
+		BiasInputWeight: RealMatrix{Rows: hidden, Cols: 1, Data: bias},
+		OutputWeight:    RealMatrix{Rows: hidden, Cols: len(b.Labels), Data: b.OutputWeight},
+	}
+
+	classifier, err := NewELMClassifier(model, b.Activation, b.Labels)
+	if err != nil {
+		return nil, err
+	}
+	classifier.Scaler = b.Scaler
+	classifier.Temperature = b.Temperature
+	return classifier, nil
+}
@@ -0,0 +1,200 @@
+package classify
+
+import (
+	"fmt"
+	"math"
+)
+
+// NonlinearOptions configures the complexity features F19–F22.
+type NonlinearOptions struct {
+	// EmbeddingDimension is the template length m of sample and approximate entropy, 2 by default.
+	EmbeddingDimension int
+	// Tolerance is the matching tolerance r of sample and approximate entropy, as a fraction of the
+	// standard deviation of the RR intervals, 0.2 by default.
+	Tolerance float64
+	// ShortScales and LongScales are the smallest and largest box sizes, in beats, of DFA α1 and α2,
+	// 4–16 and 16–64 by default.
+	ShortScales [2]int
+	LongScales  [2]int
+}
+
+// DefaultNonlinearOptions are the options of NewHRVFeature.
+var DefaultNonlinearOptions = NonlinearOptions{
+	EmbeddingDimension: 2,
+	Tolerance:          0.2,
+	ShortScales:        [2]int{4, 16},
+	LongScales:         [2]int{16, 64},
+}
+
+// Number of features of the vectors a model can consume.
+const (
+	FeatureCount         = 18
+	ExtendedFeatureCount = 22
+)
+
+// ExtendedVector returns the features in the F01..F22 order, the F01..F18 of Vector followed by
+// SampEn, ApEn, DFA α1 and DFA α2.
+func (hrv *HRVFeature) ExtendedVector() []float64 {
+	return append(hrv.Vector(),
+		hrv.F19_SampEn,
+		hrv.F20_ApEn,
+		hrv.F21_DFA1,
+		hrv.F22_DFA2,
+	)
+}
+
+// VectorOfSize returns Vector or ExtendedVector, whichever has the given number of features.
+func (hrv *HRVFeature) VectorOfSize(n int) ([]float64, error) {
+	switch n {
+	case FeatureCount:
+		return hrv.Vector(), nil
+	case ExtendedFeatureCount:
+		return hrv.ExtendedVector(), nil
+	}
+	return nil, fmt.Errorf("no feature vector of size %d, expected %d or %d", n, FeatureCount, ExtendedFeatureCount)
+}
+
+// SampleEntropy returns the sample entropy of x with templates of m samples matching within r,
+// or NaN when no template matches.
+func SampleEntropy(x []float64, m int, r float64) float64 {
+	if m < 1 || len(x) <= m+1 {
+		return math.NaN()
+	}
+
+	// Both lengths use the same N-m templates, so that the counts are comparable.
+	templates := len(x) - m
+	var b, a int
+	for i := 0; i < templates; i++ {
+		for j := i + 1; j < templates; j++ {
+			if !templatesMatch(x, i, j, m, r) {
+				continue
+			}
+			b++
+			if math.Abs(x[i+m]-x[j+m]) <= r {
+				a++
+			}
+		}
+	}
+
+	if a == 0 || b == 0 {
+		return math.NaN()
+	}
+	return -math.Log(float64(a) / float64(b))
+}
+
+// ApproximateEntropy returns the approximate entropy of x with templates of m samples matching within r.
+func ApproximateEntropy(x []float64, m int, r float64) float64 {
+	if m < 1 || len(x) <= m+1 {
+		return math.NaN()
+	}
+	return apEnPhi(x, m, r) - apEnPhi(x, m+1, r)
+}
+
+// apEnPhi is the mean log fraction of templates of m samples matching every template, itself included.
+func apEnPhi(x []float64, m int, r float64) float64 {
+	templates := len(x) - m + 1
+	phi := 0.0
+	for i := 0; i < templates; i++ {
+		matches := 0
+		for j := 0; j < templates; j++ {
+			if templatesMatch(x, i, j, m, r) {
+				matches++
+			}
+		}
+		phi += math.Log(float64(matches) / float64(templates))
+	}
+	return phi / float64(templates)
+}
+
+// templatesMatch reports whether the templates of m samples at i and j are within r of each other.
+func templatesMatch(x []float64, i, j, m int, r float64) bool {
+	for k := 0; k < m; k++ {
+		if math.Abs(x[i+k]-x[j+k]) > r {
+			return false
+		}
+	}
+	return true
+}
+
+// DFA returns the scaling exponent of detrended fluctuation analysis of x over box sizes from
+// minScale to maxScale samples, or NaN when x is too short for two box sizes.
+//
+// The integrated, mean-removed series is split in boxes, each box is linearly detrended, and the
+// exponent is the slope of the log fluctuation against the log box size.
+func DFA(x []float64, minScale, maxScale int) float64 {
+	if minScale < 2 {
+		minScale = 2
+	}
+
+	profile := make([]float64, len(x))
+	m := mean(x)
+	total := 0.0
+	for i, v := range x {
+		total += v - m
+		profile[i] = total
+	}
+
+	var logScales, logFluctuations []float64
+	for n := minScale; n <= maxScale && n <= len(x); n++ {
+		fluctuation := dfaFluctuation(profile, n)
+		if fluctuation <= 0 {
+			continue
+		}
+		logScales = append(logScales, math.Log(float64(n)))
+		logFluctuations = append(logFluctuations, math.Log(fluctuation))
+	}
+
+	if len(logScales) < 2 {
+		return math.NaN()
+	}
+	slope, _ := linearFit(logScales, logFluctuations)
+	return slope
+}
+
+// dfaFluctuation returns the root mean square of the residuals of profile around the linear fits
+// of its boxes of n samples.
+func dfaFluctuation(profile []float64, n int) float64 {
+	boxes := len(profile) / n
+	if boxes == 0 {
+		return 0
+	}
+
+	t := make([]float64, n)
+	for i := range t {
+		t[i] = float64(i)
+	}
+
+	squares := 0.0
+	for box := 0; box < boxes; box++ {
+		y := profile[box*n : (box+1)*n]
+		slope, intercept := linearFit(t, y)
+		for i, v := range y {
+			residual := v - (slope*t[i] + intercept)
+			squares += residual * residual
+		}
+	}
+	return math.Sqrt(squares / float64(boxes*n))
+}
+
+// linearFit returns the slope and intercept of the least-squares line through (x, y).
+func linearFit(x, y []float64) (float64, float64) {
+	meanX, meanY := mean(x), mean(y)
+	var cov, variance float64
+	for i := range x {
+		cov += (x[i] - meanX) * (y[i] - meanY)
+		variance += (x[i] - meanX) * (x[i] - meanX)
+	}
+	if variance == 0 {
+		return 0, meanY
+	}
+	slope := cov / variance
+	return slope, meanY - slope*meanX
+}
+
+// finiteOrZero replaces the NaN and infinities of features that can not be computed on short series by 0.
+func finiteOrZero(x float64) float64 {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return 0
+	}
+	return x
+}
@@ -0,0 +1,232 @@
+package classify
+
+import (
+	"math"
+)
+
+// Pan–Tompkins QRS detector.
+//
+// The ECG is band-passed to 5–15 Hz, differentiated, squared and integrated over a 150 ms moving
+// window. Peaks of the integrated signal are classified as QRS or noise with two adaptive thresholds,
+// with a search-back for missed beats and T-wave discrimination, and every detected QRS is located
+// on the largest absolute value of the band-passed signal.
+
+const (
+	ptLowCutoff        = 5.0
+	ptHighCutoff       = 15.0
+	ptIntegrationWidth = 0.150
+	ptRefractory       = 0.200
+	ptTWaveWindow      = 0.360
+	ptLearningPeriod   = 2.0
+	ptSearchBackFactor = 1.66
+)
+
+// DetectRPeaks returns the sample indexes of the R peaks of an ECG signal sampled at fs Hz.
+func DetectRPeaks(signal []float64, fs float64) []int {
+	if fs <= 0 || len(signal) < int(ptLearningPeriod*fs/2) || len(signal) < 8 {
+		return nil
+	}
+
+	filtered := NewBandPassFilter(fs, ptLowCutoff, ptHighCutoff).FiltFilt(signal)
+	integrated := movingWindowIntegration(square(derivative(filtered, fs)), int(math.Round(ptIntegrationWidth*fs)))
+
+	refractory := int(math.Round(ptRefractory * fs))
+	tWaveWindow := int(math.Round(ptTWaveWindow * fs))
+	searchRadius := int(math.Round(ptIntegrationWidth * fs))
+
+	// Learning phase: initialise the signal and noise levels on the first seconds.
+	learning := int(ptLearningPeriod * fs)
+	if learning > len(integrated) {
+		learning = len(integrated)
+	}
+	spki := 0.25 * max(integrated[:learning])
+	npki := 0.5 * mean(integrated[:learning])
+
+	var qrs []int
+	var qrsSlopes []float64
+	var noisePeaks []int
+	rrAverage := 0.0
+
+	threshold := func() float64 { return npki + 0.25*(spki-npki) }
+
+	accept := func(i int, fromSearchBack bool) {
+		if fromSearchBack {
+			spki = 0.25*integrated[i] + 0.75*spki
+		} else {
+			spki = 0.125*integrated[i] + 0.875*spki
+		}
+		if len(qrs) > 0 {
+			rr := float64(i - qrs[len(qrs)-1])
+			if rrAverage == 0 {
+				rrAverage = rr
+			} else {
+				rrAverage = 0.125*rr + 0.875*rrAverage
+			}
+		}
+		qrs = append(qrs, i)
+		qrsSlopes = append(qrsSlopes, maxSlope(integrated, i, searchRadius))
+	}
+
+	for _, i := range localMaxima(integrated, refractory) {
+		if len(qrs) > 0 && rrAverage > 0 && float64(i-qrs[len(qrs)-1]) > ptSearchBackFactor*rrAverage {
+			// Search back for the largest noise peak above the second threshold since the last QRS.
+			last := qrs[len(qrs)-1]
+			best := -1
+			for _, n := range noisePeaks {
+				if n > last+refractory && n < i-refractory && integrated[n] > 0.5*threshold() {
+					if best < 0 || integrated[n] > integrated[best] {
+						best = n
+					}
+				}
+			}
+			if best >= 0 {
+				accept(best, true)
+			}
+		}
+
+		if integrated[i] <= threshold() {
+			npki = 0.125*integrated[i] + 0.875*npki
+			noisePeaks = append(noisePeaks, i)
+			continue
+		}
+
+		if len(qrs) > 0 && i-qrs[len(qrs)-1] < tWaveWindow {
+			// A peak close to the previous QRS with half its slope is a T wave.
+			if maxSlope(integrated, i, searchRadius) < 0.5*qrsSlopes[len(qrsSlopes)-1] {
+				npki = 0.125*integrated[i] + 0.875*npki
+				noisePeaks = append(noisePeaks, i)
+				continue
+			}
+		}
+
+		accept(i, false)
+	}
+
+	peaks := make([]int, 0, len(qrs))
+	for _, i := range qrs {
+		peak := locateRPeak(filtered, i, searchRadius)
+		if len(peaks) > 0 && peak-peaks[len(peaks)-1] < refractory {
+			continue
+		}
+		peaks = append(peaks, peak)
+	}
+	return peaks
+}
+
+// RRIntervalSetFromECG detects the R peaks of an ECG signal sampled at fs Hz and returns its RR intervals.
+func RRIntervalSetFromECG(signal []float64, fs float64) RRIntervalSet {
+	peaks := DetectRPeaks(signal, fs)
+
+	peakTimes := make([]float64, len(peaks))
+	for i, peak := range peaks {
+		peakTimes[i] = float64(peak) / fs
+	}
+	return RRIntervalSetFromPeaks(peakTimes)
+}
+
+// RRIntervalSetFromPeaks returns the RR intervals, in seconds, between R peaks at the given times in seconds.
+func RRIntervalSetFromPeaks(peakTimes []float64) RRIntervalSet {
+	var rrIntervalValue []float64
+	for i := 1; i < len(peakTimes); i++ {
+		rrIntervalValue = append(rrIntervalValue, peakTimes[i]-peakTimes[i-1])
+	}
+	return NewRRIntervalSet(rrIntervalValue)
+}
+
+// derivative is the five-point derivative of Pan–Tompkins, centred so it adds no delay.
+func derivative(x []float64, fs float64) []float64 {
+	y := make([]float64, len(x))
+	at := func(i int) float64 {
+		if i < 0 {
+			return x[0]
+		}
+		if i >= len(x) {
+			return x[len(x)-1]
+		}
+		return x[i]
+	}
+	for i := range x {
+		y[i] = (-at(i-2) - 2*at(i-1) + 2*at(i+1) + at(i+2)) * fs / 8
+	}
+	return y
+}
+
+func square(x []float64) []float64 {
+	y := make([]float64, len(x))
+	for i, v := range x {
+		y[i] = v * v
+	}
+	return y
+}
+
+// movingWindowIntegration averages x over a centred window of the given width.
+func movingWindowIntegration(x []float64, width int) []float64 {
+	if width < 1 {
+		width = 1
+	}
+	half := width / 2
+
+	prefix := make([]float64, len(x)+1)
+	for i, v := range x {
+		prefix[i+1] = prefix[i] + v
+	}
+
+	y := make([]float64, len(x))
+	for i := range x {
+		lo := i - half
+		if lo < 0 {
+			lo = 0
+		}
+		hi := lo + width
+		if hi > len(x) {
+			hi = len(x)
+		}
+		y[i] = (prefix[hi] - prefix[lo]) / float64(width)
+	}
+	return y
+}
+
+// localMaxima returns the indexes of the local maxima of x that are at least distance samples apart,
+// keeping the larger one of two close maxima.
+func localMaxima(x []float64, distance int) []int {
+	var peaks []int
+	for i := 1; i < len(x)-1; i++ {
+		if x[i] <= x[i-1] || x[i] < x[i+1] {
+			continue
+		}
+		if len(peaks) > 0 && i-peaks[len(peaks)-1] < distance {
+			if x[i] > x[peaks[len(peaks)-1]] {
+				peaks[len(peaks)-1] = i
+			}
+			continue
+		}
+		peaks = append(peaks, i)
+	}
+	return peaks
+}
+
+// maxSlope returns the largest rising slope of x in the radius samples before i.
+func maxSlope(x []float64, i, radius int) float64 {
+	slope := 0.0
+	for j := i - radius; j < i; j++ {
+		if j < 1 {
+			continue
+		}
+		slope = math.Max(slope, x[j]-x[j-1])
+	}
+	return slope
+}
+
+// locateRPeak returns the index of the largest absolute value of x within radius samples of i.
+func locateRPeak(x []float64, i, radius int) int {
+	best := i
+	for j := i - radius; j <= i+radius; j++ {
+		if j < 0 || j >= len(x) {
+			continue
+		}
+		if math.Abs(x[j]) > math.Abs(x[best]) {
+			best = j
+		}
+	}
+	return best
+}
@@ -0,0 +1,188 @@
+package classify
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// UnscorableStage is the stage of epochs that can not be classified, as stored by the gateway.
+const UnscorableStage = "UNSCORABLE"
+
+// PipelineOptions configures the offline scoring of a recording by ScoreSignal.
+type PipelineOptions struct {
+	// EpochLength and Context are in seconds. The features of an epoch are computed on the context
+	// window centred on it, or on the epoch alone when Context is not longer than EpochLength.
+	EpochLength float64
+	Context     float64
+	// Offset is the start of the first epoch in seconds, to align the epochs on a reference scoring.
+	// The signal before it is left out.
+	Offset           float64
+	Powerline        float64
+	QualityThreshold float64
+	MaxRRCorrection  float64
+	Nonlinear        NonlinearOptions
+	// Features is the size of the feature vectors when there is no classifier, 18 or 22.
+	Features int
+}
+
+// Epoch is the result of a scoring epoch of a recording.
+type Epoch struct {
+	Start, End float64
+	Quality    SignalQuality
+	Correction RRCorrection
+	// Features is nil when the epoch is unscorable before classification.
+	Features      []float64
+	Stage         string
+	Probabilities []float64
+}
+
+// ScoreSignal splits the signal in epochs and computes their features, and their stage with the
+// classifier when it is not nil. The hypnogram is smoothed with transitions when it is not nil.
+//
+// Every epoch goes through the steps of the gateway, on the window of its context: the ECG is filtered
+// for baseline wander, powerline and high frequency noise, epochs of poor signal quality are
+// unscorable, R peaks are detected with the Pan–Tompkins detector, RR intervals are cleaned, and epochs
+// with too few beats or too many corrections are unscorable.
+func ScoreSignal(signal []float64, fs float64, classifier *ELMClassifier, transitions *TransitionMatrix, options PipelineOptions) ([]Epoch, error) {
+	features := options.Features
+	if classifier != nil {
+		features = classifier.Features()
+	}
+
+	epochLength := int(math.Round(options.EpochLength * fs))
+	if epochLength <= 0 {
+		return nil, fmt.Errorf("epoch of %g s shorter than a sample at %g Hz", options.EpochLength, fs)
+	}
+	margin := 0
+	if options.Context > options.EpochLength {
+		margin = int(math.Round((options.Context - options.EpochLength) / 2 * fs))
+	}
+
+	first := int(math.Round(options.Offset * fs))
+	if first < 0 {
+		first = 0
+	}
+
+	var epochs []Epoch
+	for start := first; start < len(signal); start += epochLength {
+		end := start + epochLength
+		if end > len(signal) {
+			end = len(signal)
+		}
+		lo, hi := start-margin, end+margin
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(signal) {
+			hi = len(signal)
+		}
+
+		window := NewWindow(signal[lo:hi], nil, fs, options.Powerline)
+		epochs = append(epochs, Epoch{
+			Start:   float64(start) / fs,
+			End:     float64(end) / fs,
+			Quality: window.Quality,
+			Stage:   UnscorableStage,
+		})
+		if !window.Quality.Acceptable(options.QualityThreshold) {
+			continue
+		}
+
+		vector, correction, err := window.Features(features, options.MaxRRCorrection, options.Nonlinear)
+		if errors.Is(err, ErrNotEnoughBeats) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		epochs[len(epochs)-1].Correction = correction
+		if vector == nil {
+			continue
+		}
+		epochs[len(epochs)-1].Features = vector
+		epochs[len(epochs)-1].Stage = ""
+	}
+
+	if classifier == nil {
+		return epochs, nil
+	}
+	if err := classifyEpochs(epochs, classifier); err != nil {
+		return nil, err
+	}
+	if transitions != nil {
+		if err := smoothEpochs(epochs, classifier.Labels, transitions); err != nil {
+			return nil, err
+		}
+	}
+	return epochs, nil
+}
+
+// classifyEpochs sets the stage of every epoch with features. Models normalising against the patient's
+// baseline use the features of the recording's epochs.
+func classifyEpochs(epochs []Epoch, classifier *ELMClassifier) error {
+	if classifier.NeedsBaseline() {
+		var recording [][]float64
+		for _, e := range epochs {
+			if e.Features != nil {
+				recording = append(recording, e.Features)
+			}
+		}
+		if len(recording) == 0 {
+			return nil
+		}
+		baseline, err := FitScaler(recording, ScalerBaseline)
+		if err != nil {
+			return err
+		}
+		if classifier, err = classifier.WithScaler(baseline); err != nil {
+			return err
+		}
+	}
+
+	for i, e := range epochs {
+		if e.Features == nil {
+			continue
+		}
+		label, scores, err := classifier.Classify(e.Features)
+		if err != nil {
+			return err
+		}
+		epochs[i].Stage = label
+		epochs[i].Probabilities = classifier.Probabilities(scores)
+	}
+	return nil
+}
+
+// smoothEpochs replaces the stages of the classified epochs by the most likely hypnogram.
+func smoothEpochs(epochs []Epoch, labels []string, transitions *TransitionMatrix) error {
+	index := make(map[string]int, len(transitions.Labels))
+	for i, label := range transitions.Labels {
+		index[label] = i
+	}
+
+	emissions := make([][]float64, len(epochs))
+	for t, e := range epochs {
+		if e.Probabilities == nil {
+			continue
+		}
+		emission := make([]float64, len(transitions.Labels))
+		for i, p := range e.Probabilities {
+			if j, ok := index[labels[i]]; ok {
+				emission[j] = p
+			}
+		}
+		emissions[t] = emission
+	}
+
+	path, err := transitions.Viterbi(emissions)
+	if err != nil {
+		return err
+	}
+	for t := range epochs {
+		if emissions[t] != nil {
+			epochs[t].Stage = path[t]
+		}
+	}
+	return nil
+}
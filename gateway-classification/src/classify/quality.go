@@ -0,0 +1,185 @@
+package classify
+
+import (
+	"math"
+)
+
+// Signal quality of ECG windows.
+//
+// bSQI is the agreement of two R-peak detectors with different sensitivities to noise: Pan–Tompkins
+// on the integrated QRS energy and a slope detector on the band-passed derivative. On clean ECG they
+// find the same beats, on noise they disagree. Flat-line and clipping detect disconnected or saturated leads.
+
+const (
+	// sqiMatchWindow is how close, in seconds, two detections of the same beat are.
+	sqiMatchWindow = 0.150
+	// flatLineDuration is the shortest run, in seconds, of constant samples counted as flat line.
+	flatLineDuration = 0.2
+	// clippingRun is the shortest run of samples at the extremes of the window counted as clipping.
+	clippingRun = 3
+	// clippingMargin is the fraction of the amplitude range considered at the extremes.
+	clippingMargin = 0.005
+)
+
+// DefaultQualityThreshold is the SignalQuality score below which a window is unscorable.
+const DefaultQualityThreshold = 0.8
+
+// SignalQuality is the quality of an ECG window.
+type SignalQuality struct {
+	// BSQI is the fraction of beats found by both detectors, between 0 and 1.
+	BSQI float64
+	// FlatLine is the fraction of samples in flat runs.
+	FlatLine float64
+	// Clipping is the fraction of samples in runs at the amplitude extremes.
+	Clipping float64
+	// Score combines the others: BSQI reduced by the flat and clipped fractions.
+	Score float64
+}
+
+// Acceptable reports whether the score reaches the threshold.
+func (q SignalQuality) Acceptable(threshold float64) bool {
+	return q.Score >= threshold
+}
+
+// AssessQuality scores an ECG window sampled at fs Hz. It is best given the filtered signal.
+func AssessQuality(signal []float64, fs float64) SignalQuality {
+	var quality SignalQuality
+	if len(signal) == 0 || fs <= 0 {
+		return quality
+	}
+
+	quality.FlatLine = flatLineFraction(signal, int(math.Ceil(flatLineDuration*fs)))
+	quality.Clipping = clippingFraction(signal)
+	quality.BSQI = BeatAgreement(DetectRPeaks(signal, fs), DetectRPeaksSlope(signal, fs), int(math.Round(sqiMatchWindow*fs)))
+	quality.Score = math.Max(0, quality.BSQI*(1-quality.FlatLine-quality.Clipping))
+	return quality
+}
+
+// BeatAgreement returns the number of beats of a and b within tolerance samples of each other,
+// divided by the number of distinct beats of both. Two empty detections agree.
+func BeatAgreement(a, b []int, tolerance int) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+
+	matched := 0
+	j := 0
+	for _, peak := range a {
+		for j < len(b) && b[j] < peak-tolerance {
+			j++
+		}
+		if j < len(b) && b[j] <= peak+tolerance {
+			matched++
+			j++
+		}
+	}
+	return float64(matched) / float64(len(a)+len(b)-matched)
+}
+
+// DetectRPeaksSlope is a second R-peak detector, used to cross-check DetectRPeaks.
+//
+// The band-passed ECG is differentiated, and beats are the points where the absolute slope crosses
+// 40% of the largest slope of the surrounding 2 seconds, at least 250 ms apart, located on the
+// largest absolute value of the band-passed signal.
+func DetectRPeaksSlope(signal []float64, fs float64) []int {
+	if fs <= 0 || len(signal) < 8 {
+		return nil
+	}
+
+	filtered := NewBandPassFilter(fs, ptLowCutoff, ptHighCutoff).FiltFilt(signal)
+	slope := derivative(filtered, fs)
+	for i, v := range slope {
+		slope[i] = math.Abs(v)
+	}
+
+	block := int(2 * fs)
+	refractory := int(math.Round(0.250 * fs))
+	radius := int(math.Round(0.075 * fs))
+
+	var peaks []int
+	for start := 0; start < len(slope); start += block {
+		end := start + block
+		if end > len(slope) {
+			end = len(slope)
+		}
+		threshold := 0.4 * max(slope[start:end])
+		if threshold == 0 {
+			continue
+		}
+
+		for i := start; i < end; i++ {
+			if slope[i] < threshold || (i > 0 && slope[i-1] >= threshold) {
+				continue
+			}
+			peak := locateRPeak(filtered, i, radius)
+			if len(peaks) > 0 && peak-peaks[len(peaks)-1] < refractory {
+				continue
+			}
+			peaks = append(peaks, peak)
+		}
+	}
+	return peaks
+}
+
+// flatLineFraction returns the fraction of samples in runs of at least minRun samples without change.
+func flatLineFraction(signal []float64, minRun int) float64 {
+	if minRun < 2 {
+		minRun = 2
+	}
+	tolerance := 1e-6 * amplitudeRange(signal)
+
+	flat := 0
+	run := 1
+	for i := 1; i <= len(signal); i++ {
+		if i < len(signal) && math.Abs(signal[i]-signal[i-1]) <= tolerance {
+			run++
+			continue
+		}
+		if run >= minRun {
+			flat += run
+		}
+		run = 1
+	}
+	return float64(flat) / float64(len(signal))
+}
+
+// clippingFraction returns the fraction of samples in runs at the minimum or maximum of the window.
+func clippingFraction(signal []float64) float64 {
+	amplitude := amplitudeRange(signal)
+	if amplitude == 0 {
+		return 0
+	}
+	low := min(signal) + clippingMargin*amplitude
+	high := max(signal) - clippingMargin*amplitude
+
+	clipped := 0
+	run := 0
+	for i := 0; i <= len(signal); i++ {
+		if i < len(signal) && (signal[i] <= low || signal[i] >= high) {
+			run++
+			continue
+		}
+		if run >= clippingRun {
+			clipped += run
+		}
+		run = 0
+	}
+	return float64(clipped) / float64(len(signal))
+}
+
+func amplitudeRange(signal []float64) float64 {
+	return max(signal) - min(signal)
+}
+
+func min(arr []float64) float64 {
+	if len(arr) == 0 {
+		return 0
+	}
+	min := arr[0]
+	for _, val := range arr {
+		if val < min {
+			min = val
+		}
+	}
+	return min
+}
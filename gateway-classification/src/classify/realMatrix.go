@@ -0,0 +1,510 @@
+package classify
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Dense linear algebra on RealMatrix, in pure Go.
+//
+// Operations that return a matrix allocate it; the *Into and *InPlace variants write into a matrix
+// of the right shape instead, so the forward pass of an ELM can reuse its buffers. Operations on
+// matrices of mismatched shapes panic, as indexing a slice out of range does.
+
+// NewRealMatrix returns a rows × cols matrix of zeros.
+func NewRealMatrix(rows, cols int) RealMatrix {
+	backing := make([]float64, rows*cols)
+	data := make([][]float64, rows)
+	for i := range data {
+		data[i] = backing[i*cols : (i+1)*cols : (i+1)*cols]
+	}
+	return RealMatrix{Rows: rows, Cols: cols, Data: data}
+}
+
+// NewRealMatrixFromData returns the matrix of the given rows, which must all have the same length.
+// The rows are not copied.
+func NewRealMatrixFromData(data [][]float64) (RealMatrix, error) {
+	if len(data) == 0 {
+		return RealMatrix{}, nil
+	}
+	cols := len(data[0])
+	for i, row := range data {
+		if len(row) != cols {
+			return RealMatrix{}, fmt.Errorf("row %d has %d columns, expected %d", i+1, len(row), cols)
+		}
+	}
+	return RealMatrix{Rows: len(data), Cols: cols, Data: data}, nil
+}
+
+// IdentityMatrix returns the n × n identity matrix.
+func IdentityMatrix(n int) RealMatrix {
+	m := NewRealMatrix(n, n)
+	for i := 0; i < n; i++ {
+		m.Data[i][i] = 1
+	}
+	return m
+}
+
+// ColumnVector returns the len(v) × 1 matrix of v.
+func ColumnVector(v []float64) RealMatrix {
+	m := NewRealMatrix(len(v), 1)
+	for i, x := range v {
+		m.Data[i][0] = x
+	}
+	return m
+}
+
+// At returns the element at row i and column j.
+func (m RealMatrix) At(i, j int) float64 {
+	return m.Data[i][j]
+}
+
+// Set sets the element at row i and column j.
+func (m RealMatrix) Set(i, j int, v float64) {
+	m.Data[i][j] = v
+}
+
+// Col returns a copy of column j.
+func (m RealMatrix) Col(j int) []float64 {
+	col := make([]float64, m.Rows)
+	for i, row := range m.Data {
+		col[i] = row[j]
+	}
+	return col
+}
+
+// Clone returns a deep copy of the matrix.
+func (m RealMatrix) Clone() RealMatrix {
+	c := NewRealMatrix(m.Rows, m.Cols)
+	for i, row := range m.Data {
+		copy(c.Data[i], row)
+	}
+	return c
+}
+
+// Transpose returns the transpose of the matrix.
+func (m RealMatrix) Transpose() RealMatrix {
+	t := NewRealMatrix(m.Cols, m.Rows)
+	for i, row := range m.Data {
+		for j, v := range row {
+			t.Data[j][i] = v
+		}
+	}
+	return t
+}
+
+// Mul returns the product m × b.
+func (m RealMatrix) Mul(b RealMatrix) RealMatrix {
+	c := NewRealMatrix(m.Rows, b.Cols)
+	m.MulInto(&c, b)
+	return c
+}
+
+// MulInto writes the product m × b into dst, which must be m.Rows × b.Cols and must not share data with m or b.
+func (m RealMatrix) MulInto(dst *RealMatrix, b RealMatrix) {
+	if m.Cols != b.Rows {
+		panic(fmt.Sprintf("classify: multiplying %dx%d by %dx%d", m.Rows, m.Cols, b.Rows, b.Cols))
+	}
+	checkShape(*dst, m.Rows, b.Cols)
+
+	for i, row := range m.Data {
+		out := dst.Data[i]
+		for j := range out {
+			out[j] = 0
+		}
+		for k, v := range row {
+			if v == 0 {
+				continue
+			}
+			for j, w := range b.Data[k] {
+				out[j] += v * w
+			}
+		}
+	}
+}
+
+// MulVec returns the product m × x of the matrix and a column vector.
+func (m RealMatrix) MulVec(x []float64) []float64 {
+	y := make([]float64, m.Rows)
+	m.MulVecInto(y, x)
+	return y
+}
+
+// MulVecInto writes the product m × x into dst, which must have m.Rows elements.
+func (m RealMatrix) MulVecInto(dst, x []float64) {
+	if len(x) != m.Cols || len(dst) != m.Rows {
+		panic(fmt.Sprintf("classify: multiplying %dx%d by a vector of %d into %d", m.Rows, m.Cols, len(x), len(dst)))
+	}
+	for i, row := range m.Data {
+		s := 0.0
+		for j, w := range row {
+			s += w * x[j]
+		}
+		dst[i] = s
+	}
+}
+
+// VecMulInto writes the product xᵀ × m of a row vector and the matrix into dst, which must have m.Cols elements.
+func (m RealMatrix) VecMulInto(dst, x []float64) {
+	if len(x) != m.Rows || len(dst) != m.Cols {
+		panic(fmt.Sprintf("classify: multiplying a vector of %d by %dx%d into %d", len(x), m.Rows, m.Cols, len(dst)))
+	}
+	for j := range dst {
+		dst[j] = 0
+	}
+	for i, row := range m.Data {
+		if x[i] == 0 {
+			continue
+		}
+		for j, w := range row {
+			dst[j] += x[i] * w
+		}
+	}
+}
+
+// Add returns the element-wise sum m + b.
+func (m RealMatrix) Add(b RealMatrix) RealMatrix {
+	c := m.Clone()
+	c.AddInPlace(b)
+	return c
+}
+
+// AddInPlace adds b to the matrix element-wise.
+func (m *RealMatrix) AddInPlace(b RealMatrix) {
+	checkShape(b, m.Rows, m.Cols)
+	for i, row := range m.Data {
+		for j, v := range b.Data[i] {
+			row[j] += v
+		}
+	}
+}
+
+// Sub returns the element-wise difference m − b.
+func (m RealMatrix) Sub(b RealMatrix) RealMatrix {
+	c := m.Clone()
+	c.SubInPlace(b)
+	return c
+}
+
+// SubInPlace subtracts b from the matrix element-wise.
+func (m *RealMatrix) SubInPlace(b RealMatrix) {
+	checkShape(b, m.Rows, m.Cols)
+	for i, row := range m.Data {
+		for j, v := range b.Data[i] {
+			row[j] -= v
+		}
+	}
+}
+
+// MulElem returns the element-wise (Hadamard) product of m and b.
+func (m RealMatrix) MulElem(b RealMatrix) RealMatrix {
+	c := m.Clone()
+	c.MulElemInPlace(b)
+	return c
+}
+
+// MulElemInPlace multiplies the matrix by b element-wise.
+func (m *RealMatrix) MulElemInPlace(b RealMatrix) {
+	checkShape(b, m.Rows, m.Cols)
+	for i, row := range m.Data {
+		for j, v := range b.Data[i] {
+			row[j] *= v
+		}
+	}
+}
+
+// Scale returns the matrix multiplied by s.
+func (m RealMatrix) Scale(s float64) RealMatrix {
+	c := m.Clone()
+	c.ScaleInPlace(s)
+	return c
+}
+
+// ScaleInPlace multiplies every element of the matrix by s.
+func (m *RealMatrix) ScaleInPlace(s float64) {
+	for _, row := range m.Data {
+		for j := range row {
+			row[j] *= s
+		}
+	}
+}
+
+// Apply returns the matrix with f applied to every element, such as the activation of a hidden layer.
+func (m RealMatrix) Apply(f func(float64) float64) RealMatrix {
+	c := m.Clone()
+	c.ApplyInPlace(f)
+	return c
+}
+
+// ApplyInPlace applies f to every element of the matrix.
+func (m *RealMatrix) ApplyInPlace(f func(float64) float64) {
+	for _, row := range m.Data {
+		for j, v := range row {
+			row[j] = f(v)
+		}
+	}
+}
+
+// AddRowVector returns the matrix with v added to every row.
+func (m RealMatrix) AddRowVector(v []float64) RealMatrix {
+	c := m.Clone()
+	c.AddRowVectorInPlace(v)
+	return c
+}
+
+// AddRowVectorInPlace adds v, which must have m.Cols elements, to every row of the matrix.
+func (m *RealMatrix) AddRowVectorInPlace(v []float64) {
+	if len(v) != m.Cols {
+		panic(fmt.Sprintf("classify: adding a row of %d to %dx%d", len(v), m.Rows, m.Cols))
+	}
+	for _, row := range m.Data {
+		for j, x := range v {
+			row[j] += x
+		}
+	}
+}
+
+// AddColVectorInPlace adds v, which must have m.Rows elements, to every column of the matrix.
+func (m *RealMatrix) AddColVectorInPlace(v []float64) {
+	if len(v) != m.Rows {
+		panic(fmt.Sprintf("classify: adding a column of %d to %dx%d", len(v), m.Rows, m.Cols))
+	}
+	for i, row := range m.Data {
+		for j := range row {
+			row[j] += v[i]
+		}
+	}
+}
+
+// AddDiagonalInPlace adds lambda to the diagonal of the matrix.
+func (m *RealMatrix) AddDiagonalInPlace(lambda float64) {
+	for i := 0; i < m.Rows && i < m.Cols; i++ {
+		m.Data[i][i] += lambda
+	}
+}
+
+func checkShape(m RealMatrix, rows, cols int) {
+	if m.Rows != rows || m.Cols != cols || len(m.Data) != rows {
+		panic(fmt.Sprintf("classify: matrix is %dx%d, expected %dx%d", m.Rows, m.Cols, rows, cols))
+	}
+}
+
+// errNotPositiveDefinite is returned by Cholesky for matrices that are not symmetric positive definite.
+var errNotPositiveDefinite = errors.New("matrix is not positive definite, increase the ridge regularisation")
+
+// Cholesky returns the lower triangular L with LLᵀ = m, for a symmetric positive definite m.
+func (m RealMatrix) Cholesky() (RealMatrix, error) {
+	if m.Rows != m.Cols {
+		return RealMatrix{}, fmt.Errorf("Cholesky of a %dx%d matrix", m.Rows, m.Cols)
+	}
+
+	n := m.Rows
+	l := NewRealMatrix(n, n)
+	for i := 0; i < n; i++ {
+		for j := 0; j <= i; j++ {
+			s := m.Data[i][j]
+			for k := 0; k < j; k++ {
+				s -= l.Data[i][k] * l.Data[j][k]
+			}
+			if i == j {
+				if !(s > 0) {
+					return RealMatrix{}, errNotPositiveDefinite
+				}
+				l.Data[i][i] = math.Sqrt(s)
+			} else {
+				l.Data[i][j] = s / l.Data[j][j]
+			}
+		}
+	}
+	return l, nil
+}
+
+// SolveSPD returns the X solving m × X = b for a symmetric positive definite m, through its Cholesky factor.
+func (m RealMatrix) SolveSPD(b RealMatrix) (RealMatrix, error) {
+	if b.Rows != m.Rows {
+		return RealMatrix{}, fmt.Errorf("solving %dx%d for %dx%d", m.Rows, m.Cols, b.Rows, b.Cols)
+	}
+	l, err := m.Cholesky()
+	if err != nil {
+		return RealMatrix{}, err
+	}
+
+	n := m.Rows
+	x := NewRealMatrix(n, b.Cols)
+	y := make([]float64, n)
+	for c := 0; c < b.Cols; c++ {
+		// Forward substitution of Ly = b, then back substitution of Lᵀx = y.
+		for i := 0; i < n; i++ {
+			s := b.Data[i][c]
+			for k := 0; k < i; k++ {
+				s -= l.Data[i][k] * y[k]
+			}
+			y[i] = s / l.Data[i][i]
+		}
+		for i := n - 1; i >= 0; i-- {
+			s := y[i]
+			for k := i + 1; k < n; k++ {
+				s -= l.Data[k][i] * x.Data[k][c]
+			}
+			x.Data[i][c] = s / l.Data[i][i]
+		}
+	}
+	return x, nil
+}
+
+// svdMaxSweeps bounds the number of Jacobi sweeps of SVD.
+const svdMaxSweeps = 60
+
+// SVD returns the thin singular value decomposition m = U × diag(S) × Vᵀ, with the singular values in
+// decreasing order. U is m.Rows × k, V is m.Cols × k and k is the smaller dimension.
+//
+// It uses one-sided Jacobi rotations, which are accurate for the small singular values the
+// pseudoinverse of an ill-conditioned hidden layer depends on.
+func (m RealMatrix) SVD() (u RealMatrix, s []float64, v RealMatrix, err error) {
+	if m.Rows < m.Cols {
+		v, s, u, err = m.Transpose().SVD()
+		return u, s, v, err
+	}
+
+	// The rows of a are the columns of m, so rotations work on contiguous slices.
+	a := m.Transpose()
+	n := m.Cols
+	vt := IdentityMatrix(n)
+
+	// Columns reduced to rounding noise of the matrix are not rotated any further, as their angle to
+	// the other columns never converges. This happens to the null space of rank deficient matrices.
+	negligible := 0.0
+	for _, col := range a.Data {
+		negligible += dot(col, col)
+	}
+	negligible *= 1e-30
+
+	converged := false
+	for sweep := 0; sweep < svdMaxSweeps && !converged; sweep++ {
+		converged = true
+		for p := 0; p < n-1; p++ {
+			for q := p + 1; q < n; q++ {
+				ap, aq := a.Data[p], a.Data[q]
+				alpha, beta, gamma := 0.0, 0.0, 0.0
+				for i := range ap {
+					alpha += ap[i] * ap[i]
+					beta += aq[i] * aq[i]
+					gamma += ap[i] * aq[i]
+				}
+				if gamma == 0 || math.Abs(gamma) <= 1e-15*math.Sqrt(alpha*beta) || alpha <= negligible || beta <= negligible {
+					continue
+				}
+				converged = false
+
+				zeta := (beta - alpha) / (2 * gamma)
+				t := math.Copysign(1, zeta) / (math.Abs(zeta) + math.Sqrt(1+zeta*zeta))
+				c := 1 / math.Sqrt(1+t*t)
+				sn := c * t
+				rotate(ap, aq, c, sn)
+				rotate(vt.Data[p], vt.Data[q], c, sn)
+			}
+		}
+	}
+	if !converged {
+		return RealMatrix{}, nil, RealMatrix{}, errors.New("SVD did not converge")
+	}
+
+	s = make([]float64, n)
+	for j, col := range a.Data {
+		s[j] = math.Sqrt(dot(col, col))
+		if s[j] > 0 {
+			for i := range col {
+				col[i] /= s[j]
+			}
+		}
+	}
+
+	// Sort the singular values, and their vectors, in decreasing order.
+	order := make([]int, n)
+	for j := range order {
+		order[j] = j
+	}
+	for i := 1; i < n; i++ {
+		for j := i; j > 0 && s[order[j]] > s[order[j-1]]; j-- {
+			order[j], order[j-1] = order[j-1], order[j]
+		}
+	}
+
+	sorted := make([]float64, n)
+	ut := RealMatrix{Rows: n, Cols: m.Rows, Data: make([][]float64, n)}
+	vtSorted := RealMatrix{Rows: n, Cols: n, Data: make([][]float64, n)}
+	for k, j := range order {
+		sorted[k] = s[j]
+		ut.Data[k] = a.Data[j]
+		vtSorted.Data[k] = vt.Data[j]
+	}
+	return ut.Transpose(), sorted, vtSorted.Transpose(), nil
+}
+
+func rotate(x, y []float64, c, s float64) {
+	for i := range x {
+		xi, yi := x[i], y[i]
+		x[i] = c*xi - s*yi
+		y[i] = s*xi + c*yi
+	}
+}
+
+func dot(x, y []float64) float64 {
+	s := 0.0
+	for i := range x {
+		s += x[i] * y[i]
+	}
+	return s
+}
+
+// PseudoInverse returns the Moore–Penrose pseudoinverse of the matrix through its SVD. Singular values
+// below max(rows, cols) × σmax × ε are treated as zero.
+func (m RealMatrix) PseudoInverse() (RealMatrix, error) {
+	u, s, v, err := m.SVD()
+	if err != nil {
+		return RealMatrix{}, err
+	}
+	if len(s) == 0 {
+		return NewRealMatrix(m.Cols, m.Rows), nil
+	}
+
+	tolerance := float64(m.Rows)
+	if m.Cols > m.Rows {
+		tolerance = float64(m.Cols)
+	}
+	tolerance *= s[0] * 2.220446049250313e-16
+
+	// m⁺ = V × diag(1/S) × Uᵀ
+	scaled := v.Clone()
+	for _, row := range scaled.Data {
+		for k := range row {
+			if s[k] > tolerance {
+				row[k] /= s[k]
+			} else {
+				row[k] = 0
+			}
+		}
+	}
+	return scaled.Mul(u.Transpose()), nil
+}
+
+// RidgePseudoInverse returns the ridge-regularised pseudoinverse (mᵀm + λI)⁻¹mᵀ, computed through the
+// Cholesky factor of the smaller of mᵀm and mmᵀ. lambda must be positive unless m has full rank.
+func (m RealMatrix) RidgePseudoInverse(lambda float64) (RealMatrix, error) {
+	mt := m.Transpose()
+	if m.Rows >= m.Cols {
+		gram := mt.Mul(m)
+		gram.AddDiagonalInPlace(lambda)
+		return gram.SolveSPD(mt)
+	}
+
+	// (mᵀm + λI)⁻¹mᵀ = mᵀ(mmᵀ + λI)⁻¹
+	gram := m.Mul(mt)
+	gram.AddDiagonalInPlace(lambda)
+	inverse, err := gram.SolveSPD(IdentityMatrix(m.Rows))
+	if err != nil {
+		return RealMatrix{}, err
+	}
+	return mt.Mul(inverse), nil
+}
@@ -0,0 +1,156 @@
+package classify
+
+import (
+	"math"
+	"testing"
+)
+
+func matrix(t *testing.T, data [][]float64) RealMatrix {
+	t.Helper()
+	m, err := NewRealMatrixFromData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func assertMatrixNear(t *testing.T, name string, got, want RealMatrix, tolerance float64) {
+	t.Helper()
+	if got.Rows != want.Rows || got.Cols != want.Cols {
+		t.Fatalf("%s is %dx%d, want %dx%d", name, got.Rows, got.Cols, want.Rows, want.Cols)
+	}
+	for i := range want.Data {
+		for j := range want.Data[i] {
+			if math.Abs(got.Data[i][j]-want.Data[i][j]) > tolerance {
+				t.Fatalf("%s[%d][%d] = %g, want %g", name, i, j, got.Data[i][j], want.Data[i][j])
+			}
+		}
+	}
+}
+
+func TestPseudoInverse(t *testing.T) {
+	tests := []struct {
+		name string
+		data [][]float64
+	}{
+		{"full rank square", [][]float64{{4, 1}, {2, 3}}},
+		{"rank deficient square", [][]float64{{1, 2, 3}, {2, 4, 6}, {1, 0, 1}}},
+		{"tall rank one", [][]float64{{1, 2}, {2, 4}, {3, 6}, {-1, -2}}},
+		{"tall full rank", [][]float64{{1, 0}, {0, 1}, {1, 1}, {2, -1}}},
+		{"wide rank deficient", [][]float64{{1, 2, 0, 1}, {2, 4, 0, 2}}},
+		{"zero", [][]float64{{0, 0, 0}, {0, 0, 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := matrix(t, tt.data)
+			pinv, err := a.PseudoInverse()
+			if err != nil {
+				t.Fatalf("PseudoInverse: %v", err)
+			}
+			if pinv.Rows != a.Cols || pinv.Cols != a.Rows {
+				t.Fatalf("pseudoinverse is %dx%d, want %dx%d", pinv.Rows, pinv.Cols, a.Cols, a.Rows)
+			}
+			assertMatrixNear(t, "A·A⁺·A", a.Mul(pinv).Mul(a), a, 1e-9)
+			assertMatrixNear(t, "A⁺·A·A⁺", pinv.Mul(a).Mul(pinv), pinv, 1e-9)
+		})
+	}
+}
+
+func TestSVD(t *testing.T) {
+	a := matrix(t, [][]float64{{3, 2, 2}, {2, 3, -2}})
+	u, s, v, err := a.SVD()
+	if err != nil {
+		t.Fatalf("SVD: %v", err)
+	}
+	// The singular values of this matrix are 5 and 3.
+	if len(s) != 2 || math.Abs(s[0]-5) > 1e-12 || math.Abs(s[1]-3) > 1e-12 {
+		t.Fatalf("singular values %v, want [5 3]", s)
+	}
+
+	scaled := u.Clone()
+	for _, row := range scaled.Data {
+		for k := range row {
+			row[k] *= s[k]
+		}
+	}
+	assertMatrixNear(t, "U·S·Vᵀ", scaled.Mul(v.Transpose()), a, 1e-12)
+}
+
+// ridgeH is a small hidden layer whose ridge solutions are worked out by hand: with λ = 1,
+// HᵀH + λI = [[3 1] [1 3]], whose inverse is [[3 -1] [-1 3]] / 8.
+var ridgeH = [][]float64{{1, 0}, {0, 1}, {1, 1}}
+
+func TestRidgePseudoInverse(t *testing.T) {
+	h := matrix(t, ridgeH)
+
+	// (HᵀH + I)⁻¹Hᵀ
+	got, err := h.RidgePseudoInverse(1)
+	if err != nil {
+		t.Fatalf("RidgePseudoInverse: %v", err)
+	}
+	want := matrix(t, [][]float64{{3, -1, 2}, {-1, 3, 2}}).Scale(1.0 / 8)
+	assertMatrixNear(t, "tall", got, want, 1e-12)
+
+	// For M = Hᵀ, (MᵀM + I)⁻¹Mᵀ = Mᵀ(MMᵀ + I)⁻¹ = H(HᵀH + I)⁻¹.
+	got, err = h.Transpose().RidgePseudoInverse(1)
+	if err != nil {
+		t.Fatalf("RidgePseudoInverse: %v", err)
+	}
+	want = matrix(t, [][]float64{{3, -1}, {-1, 3}, {2, 2}}).Scale(1.0 / 8)
+	assertMatrixNear(t, "wide", got, want, 1e-12)
+}
+
+func TestRidgeSolve(t *testing.T) {
+	h := matrix(t, ridgeH)
+
+	tests := []struct {
+		name   string
+		h      RealMatrix
+		t      [][]float64
+		lambda float64
+		want   [][]float64
+	}{
+		// β = (HᵀH + I)⁻¹HᵀT, with HᵀT = [2 1].
+		{"more samples than hidden nodes", h, [][]float64{{1}, {0}, {1}}, 1, [][]float64{{5.0 / 8}, {1.0 / 8}}},
+		// β = H(HᵀH + I)⁻¹T for the transposed layer.
+		{"fewer samples than hidden nodes", h.Transpose(), [][]float64{{1}, {0}}, 1, [][]float64{{3.0 / 8}, {-1.0 / 8}, {2.0 / 8}}},
+		// T is in the column space of H, so the least squares solution fits it exactly.
+		{"no regularisation", h, [][]float64{{1}, {0}, {1}}, 0, [][]float64{{1}, {0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ridgeSolve(tt.h, matrix(t, tt.t), tt.lambda)
+			if err != nil {
+				t.Fatalf("ridgeSolve: %v", err)
+			}
+			assertMatrixNear(t, "β", got, matrix(t, tt.want), 1e-12)
+		})
+	}
+}
+
+func TestRidgeSolveMatchesNormalEquations(t *testing.T) {
+	// A rank deficient layer: the ridge keeps the normal equations solvable.
+	h := matrix(t, [][]float64{{1, 2, 1}, {2, 4, 0}, {3, 6, 1}, {0, 0, 2}, {1, 2, -1}})
+	targets := matrix(t, [][]float64{{1, 0}, {0, 1}, {1, 0}, {0, 1}, {1, 0}})
+	lambda := 0.1
+
+	got, err := ridgeSolve(h, targets, lambda)
+	if err != nil {
+		t.Fatalf("ridgeSolve: %v", err)
+	}
+
+	gram := h.Transpose().Mul(h)
+	gram.AddDiagonalInPlace(lambda)
+	want, err := gram.SolveSPD(h.Transpose().Mul(targets))
+	if err != nil {
+		t.Fatalf("SolveSPD: %v", err)
+	}
+	assertMatrixNear(t, "β", got, want, 1e-9)
+
+	// The wide form through HHᵀ gives the same solution.
+	wide, err := h.RidgePseudoInverse(lambda)
+	if err != nil {
+		t.Fatalf("RidgePseudoInverse: %v", err)
+	}
+	assertMatrixNear(t, "(HᵀH + λI)⁻¹HᵀT", wide.Mul(targets), want, 1e-9)
+}
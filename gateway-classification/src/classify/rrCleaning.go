@@ -0,0 +1,152 @@
+package classify
+
+import (
+	"math"
+	"sort"
+)
+
+// RR-series cleaning.
+//
+// Every interval is compared to the median of the intervals around it. Physiologically impossible
+// intervals are artifacts, two short intervals adding up to one normal interval are an extra
+// detection, an interval of two or three normal intervals is a missed beat, and a short interval
+// followed by a compensatory long one is an ectopic beat.
+
+const (
+	minRRInterval      = 0.3
+	maxRRInterval      = 2.0
+	rrMedianNeighbours = 5
+	ectopicTolerance   = 0.2
+	outlierTolerance   = 0.3
+)
+
+// RRCorrection counts the corrections made by CleanRRIntervals.
+type RRCorrection struct {
+	Ectopic   int
+	Missed    int
+	Extra     int
+	Artifacts int
+	// Corrected is the fraction of the input intervals that were corrected.
+	Corrected float64
+}
+
+// CleanRRIntervals corrects the ectopic beats, missed and extra detections and artifacts of RR
+// intervals in seconds, and returns the cleaned intervals.
+//
+// Extra detections are merged, missed beats are split into equal intervals, and an ectopic pair
+// is replaced by two intervals of its mean, which keeps the beat times that follow. Artifacts
+// and remaining outliers are replaced by the local median.
+func CleanRRIntervals(rrIntervalValue []float64) ([]float64, RRCorrection) {
+	var correction RRCorrection
+	if len(rrIntervalValue) == 0 {
+		return nil, correction
+	}
+
+	medians := localMedians(rrIntervalValue)
+	cleaned := make([]float64, 0, len(rrIntervalValue))
+	corrected := 0
+
+	for i := 0; i < len(rrIntervalValue); i++ {
+		rr := rrIntervalValue[i]
+		m := medians[i]
+		hasNext := i+1 < len(rrIntervalValue)
+
+		switch {
+		case hasNext && rr < (1-outlierTolerance)*m && math.Abs(rr+rrIntervalValue[i+1]-m) < ectopicTolerance*m:
+			cleaned = append(cleaned, rr+rrIntervalValue[i+1])
+			correction.Extra++
+			corrected += 2
+			i++
+
+		case rr > (1+outlierTolerance+ectopicTolerance)*m && isMultiple(rr, m):
+			beats := int(math.Round(rr / m))
+			for k := 0; k < beats; k++ {
+				cleaned = append(cleaned, rr/float64(beats))
+			}
+			correction.Missed++
+			corrected++
+
+		case hasNext && rr < (1-ectopicTolerance)*m && rrIntervalValue[i+1] > (1+ectopicTolerance)*m:
+			pair := (rr + rrIntervalValue[i+1]) / 2
+			cleaned = append(cleaned, pair, pair)
+			correction.Ectopic++
+			corrected += 2
+			i++
+
+		case rr < minRRInterval || rr > maxRRInterval || math.Abs(rr-m) > outlierTolerance*m:
+			cleaned = append(cleaned, m)
+			correction.Artifacts++
+			corrected++
+
+		default:
+			cleaned = append(cleaned, rr)
+		}
+	}
+
+	correction.Corrected = float64(corrected) / float64(len(rrIntervalValue))
+	return cleaned, correction
+}
+
+// NewRRIntervalSet returns the RR interval set of RR intervals in seconds.
+func NewRRIntervalSet(rrIntervalValue []float64) RRIntervalSet {
+	rrIntervalSet := RRIntervalSet{RRIntervalValue: rrIntervalValue}
+	for i := 1; i < len(rrIntervalValue); i++ {
+		diff := rrIntervalValue[i] - rrIntervalValue[i-1]
+		rrIntervalSet.RRIntervalsValueDiff = append(rrIntervalSet.RRIntervalsValueDiff, diff)
+	}
+	return rrIntervalSet
+}
+
+// isMultiple reports whether rr is close to two or three times m.
+func isMultiple(rr, m float64) bool {
+	ratio := rr / m
+	beats := math.Round(ratio)
+	return beats >= 2 && beats <= 3 && math.Abs(ratio-beats) < ectopicTolerance*beats
+}
+
+// localMedians returns the median of the plausible intervals around every interval, itself excluded.
+// Intervals without plausible neighbours get the median of the whole series.
+func localMedians(rrIntervalValue []float64) []float64 {
+	plausible := func(rr float64) bool {
+		return rr >= minRRInterval && rr <= maxRRInterval
+	}
+
+	var all []float64
+	for _, rr := range rrIntervalValue {
+		if plausible(rr) {
+			all = append(all, rr)
+		}
+	}
+	global := median(all)
+	if global == 0 {
+		global = median(append([]float64(nil), rrIntervalValue...))
+	}
+
+	medians := make([]float64, len(rrIntervalValue))
+	for i := range rrIntervalValue {
+		var neighbours []float64
+		for j := i - rrMedianNeighbours; j <= i+rrMedianNeighbours; j++ {
+			if j >= 0 && j < len(rrIntervalValue) && j != i && plausible(rrIntervalValue[j]) {
+				neighbours = append(neighbours, rrIntervalValue[j])
+			}
+		}
+		medians[i] = global
+		if len(neighbours) > 0 {
+			medians[i] = median(neighbours)
+		}
+	}
+	return medians
+}
+
+// median returns the median of arr, which it sorts, or 0 if it is empty.
+func median(arr []float64) float64 {
+	if len(arr) == 0 {
+		return 0
+	}
+	sort.Float64s(arr)
+	middle := len(arr) / 2
+	if len(arr)%2 == 0 {
+		return (arr[middle-1] + arr[middle]) / 2
+	}
+	return arr[middle]
+}
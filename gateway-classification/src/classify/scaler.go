@@ -0,0 +1,129 @@
+package classify
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Scaling methods of a Scaler.
+const (
+	// ScalerZScore standardises every feature with the mean and standard deviation of the training features.
+	ScalerZScore = "zscore"
+	// ScalerMinMax rescales every feature to [0, 1] with the range of the training features.
+	ScalerMinMax = "minmax"
+	// ScalerBaseline standardises every feature with the mean and standard deviation of the recording
+	// it comes from, so features are relative to the patient's own baseline of the night.
+	ScalerBaseline = "baseline"
+)
+
+// Scaler normalises feature vectors before the forward pass of an ELM.
+//
+// A baseline scaler stored with a model holds no parameters: it is fitted on the features of every
+// recording at training time, and on the features of the classified recording at inference.
+type Scaler struct {
+	// Method is ScalerZScore, ScalerMinMax or ScalerBaseline. Empty is ScalerZScore.
+	Method string    `json:"method,omitempty"`
+	Mean   []float64 `json:"mean"`
+	Std    []float64 `json:"std"`
+	Min    []float64 `json:"min,omitempty"`
+	Max    []float64 `json:"max,omitempty"`
+}
+
+// FitScaler computes the parameters of a scaler with the given method on a set of feature vectors.
+// NaN features are ignored. Constant features are only centred by z-score and baseline scalers,
+// and mapped to 0 by min-max scalers.
+func FitScaler(features [][]float64, method string) (*Scaler, error) {
+	if method == "" {
+		method = ScalerZScore
+	}
+	if method != ScalerZScore && method != ScalerMinMax && method != ScalerBaseline {
+		return nil, fmt.Errorf("unknown scaler %q", method)
+	}
+	if len(features) == 0 {
+		return nil, errors.New("no features to fit the scaler on")
+	}
+
+	n := len(features[0])
+	scaler := &Scaler{Method: method}
+	column := make([]float64, len(features))
+	for j := 0; j < n; j++ {
+		for i, x := range features {
+			column[i] = x[j]
+		}
+
+		if method == ScalerMinMax {
+			scaler.Min = append(scaler.Min, nanmin(column))
+			scaler.Max = append(scaler.Max, nanmax(column))
+			continue
+		}
+		mean, std := nanmean(column), nanstd(column)
+		if math.IsNaN(mean) {
+			mean = 0
+		}
+		if std == 0 || math.IsNaN(std) {
+			std = 1
+		}
+		scaler.Mean = append(scaler.Mean, mean)
+		scaler.Std = append(scaler.Std, std)
+	}
+	return scaler, nil
+}
+
+// Fitted reports whether the scaler holds parameters, which a baseline scaler of a model does not.
+func (s *Scaler) Fitted() bool {
+	if s.Method == ScalerMinMax {
+		return len(s.Min) > 0
+	}
+	return len(s.Mean) > 0
+}
+
+// Transform returns the normalised copy of x.
+func (s *Scaler) Transform(x []float64) []float64 {
+	y := make([]float64, len(x))
+	for j, v := range x {
+		if s.Method == ScalerMinMax {
+			span := s.Max[j] - s.Min[j]
+			if span == 0 {
+				span = 1
+			}
+			y[j] = (v - s.Min[j]) / span
+			continue
+		}
+		y[j] = (v - s.Mean[j]) / s.Std[j]
+	}
+	return y
+}
+
+// Validate checks that the scaler normalises vectors of n features. A baseline scaler may be unfitted.
+func (s *Scaler) Validate(n int) error {
+	switch s.Method {
+	case "", ScalerZScore, ScalerBaseline:
+		if s.Method == ScalerBaseline && len(s.Mean) == 0 && len(s.Std) == 0 {
+			return nil
+		}
+		if len(s.Mean) != n || len(s.Std) != n {
+			return fmt.Errorf("scaler has %d means and %d standard deviations, expected %d", len(s.Mean), len(s.Std), n)
+		}
+		for j := range s.Mean {
+			if math.IsNaN(s.Mean[j]) || math.IsInf(s.Mean[j], 0) {
+				return fmt.Errorf("scaler mean %d is %g", j, s.Mean[j])
+			}
+			if !(s.Std[j] > 0) || math.IsInf(s.Std[j], 0) {
+				return fmt.Errorf("scaler standard deviation %d is %g", j, s.Std[j])
+			}
+		}
+	case ScalerMinMax:
+		if len(s.Min) != n || len(s.Max) != n {
+			return fmt.Errorf("scaler has %d minimums and %d maximums, expected %d", len(s.Min), len(s.Max), n)
+		}
+		for j := range s.Min {
+			if math.IsNaN(s.Min[j]) || math.IsInf(s.Min[j], 0) || math.IsNaN(s.Max[j]) || math.IsInf(s.Max[j], 0) || s.Max[j] < s.Min[j] {
+				return fmt.Errorf("scaler range %d is [%g, %g]", j, s.Min[j], s.Max[j])
+			}
+		}
+	default:
+		return fmt.Errorf("unknown scaler %q", s.Method)
+	}
+	return nil
+}
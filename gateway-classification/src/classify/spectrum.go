@@ -0,0 +1,159 @@
+package classify
+
+import (
+	"math"
+)
+
+// welchSegment is the length, in samples, of the segments averaged by welchPSD.
+const welchSegment = 256
+
+// resampleRR linearly interpolates the RR intervals on an even grid at Fs Hz.
+//
+// Each interval is placed at the time of the beat ending it, so the series starts at the second beat.
+func resampleRR(rrIntervalValue []float64, Fs float64) []float64 {
+	if len(rrIntervalValue) < 2 || Fs <= 0 {
+		return nil
+	}
+
+	times := make([]float64, len(rrIntervalValue))
+	elapsed := 0.0
+	for i, rr := range rrIntervalValue {
+		elapsed += rr / 1000
+		times[i] = elapsed
+	}
+
+	n := int(math.Floor((times[len(times)-1]-times[0])*Fs)) + 1
+	resampled := make([]float64, n)
+	j := 0
+	for i := range resampled {
+		t := times[0] + float64(i)/Fs
+		for j < len(times)-2 && times[j+1] < t {
+			j++
+		}
+		span := times[j+1] - times[j]
+		if span <= 0 {
+			resampled[i] = rrIntervalValue[j]
+			continue
+		}
+		ratio := (t - times[j]) / span
+		resampled[i] = rrIntervalValue[j] + ratio*(rrIntervalValue[j+1]-rrIntervalValue[j])
+	}
+	return resampled
+}
+
+// detrend removes the least-squares line from x.
+func detrend(x []float64) []float64 {
+	n := float64(len(x))
+	if len(x) < 2 {
+		return x
+	}
+
+	meanT := (n - 1) / 2
+	meanX := mean(x)
+	var cov, variance float64
+	for i, v := range x {
+		cov += (float64(i) - meanT) * (v - meanX)
+		variance += (float64(i) - meanT) * (float64(i) - meanT)
+	}
+	slope := cov / variance
+
+	y := make([]float64, len(x))
+	for i, v := range x {
+		y[i] = v - meanX - slope*(float64(i)-meanT)
+	}
+	return y
+}
+
+// HannWindow returns the symmetric Hann window of n samples.
+func HannWindow(n int) []float64 {
+	return cosineWindow(n, 0.5, 0.5)
+}
+
+// HammingWindow returns the symmetric Hamming window of n samples.
+func HammingWindow(n int) []float64 {
+	return cosineWindow(n, 0.54, 0.46)
+}
+
+func cosineWindow(n int, a0, a1 float64) []float64 {
+	window := make([]float64, n)
+	if n == 1 {
+		window[0] = 1
+		return window
+	}
+	for i := range window {
+		window[i] = a0 - a1*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+	}
+	return window
+}
+
+// WelchOptions configures WelchPSD. Zero values select the defaults.
+type WelchOptions struct {
+	// SegmentLength is the length of the averaged segments, 256 samples by default.
+	// Signals shorter than a segment are used as a single segment.
+	SegmentLength int
+	// Overlap is the number of samples shared by consecutive segments, half a segment by default.
+	Overlap int
+	// NFFT is the length segments are zero-padded to, the next power of two by default.
+	NFFT int
+	// Window returns the window applied to every segment, HammingWindow by default.
+	Window func(n int) []float64
+}
+
+// WelchPSD estimates the one-sided power spectral density of x sampled at fs Hz by averaging the
+// periodograms of windowed, overlapping segments. It returns the densities and their frequencies in Hz.
+func WelchPSD(x []float64, fs float64, options WelchOptions) ([]float64, []float64) {
+	if len(x) < 2 || fs <= 0 {
+		return nil, nil
+	}
+
+	segment := options.SegmentLength
+	if segment <= 0 {
+		segment = welchSegment
+	}
+	if len(x) < segment {
+		segment = len(x)
+	}
+	overlap := options.Overlap
+	if overlap <= 0 || overlap >= segment {
+		overlap = segment / 2
+	}
+	step := segment - overlap
+	nfft := options.NFFT
+	if nfft < segment {
+		nfft = NextPowerOfTwo(segment)
+	}
+	windowFunc := options.Window
+	if windowFunc == nil {
+		windowFunc = HammingWindow
+	}
+
+	window := windowFunc(segment)
+	windowPower := 0.0
+	for _, w := range window {
+		windowPower += w * w
+	}
+
+	psd := make([]float64, nfft/2+1)
+	segments := 0
+	buffer := make([]float64, nfft)
+	for start := 0; start+segment <= len(x); start += step {
+		for i := 0; i < segment; i++ {
+			buffer[i] = x[start+i] * window[i]
+		}
+		for k, value := range RFFT(buffer) {
+			psd[k] += real(value)*real(value) + imag(value)*imag(value)
+		}
+		segments++
+	}
+
+	f := make([]float64, len(psd))
+	for k := range psd {
+		psd[k] /= float64(segments) * fs * windowPower
+		// Fold the negative frequencies, except DC and Nyquist which have none.
+		if k > 0 && !(nfft%2 == 0 && k == nfft/2) {
+			psd[k] *= 2
+		}
+		f[k] = float64(k) * fs / float64(nfft)
+	}
+	return psd, f
+}
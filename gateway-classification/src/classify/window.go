@@ -0,0 +1,80 @@
+package classify
+
+import (
+	"errors"
+)
+
+// ErrNotEnoughBeats is returned when a window of ECG holds too few heart beats for HRV features.
+var ErrNotEnoughBeats = errors.New("not enough heart beats for HRV features")
+
+// MinRRIntervals is the number of RR intervals needed to compute the HRV features of a window.
+const MinRRIntervals = 3
+
+// Window is a window of ECG prepared for classification: the steps the gateway runs on every epoch,
+// shared with the offline scoring of recordings.
+type Window struct {
+	// Times are the times of the samples in seconds, nil for samples evenly spaced at SamplingRate.
+	Times []float64
+	// SamplingRate is the sampling rate in Hz.
+	SamplingRate float64
+	// Filtered is the ECG filtered for baseline wander, powerline and high frequency noise without phase delay.
+	Filtered []float64
+	Quality  SignalQuality
+}
+
+// NewWindow filters a window of ECG sampled at fs Hz and assesses its signal quality.
+//
+// times are the times of the samples in seconds, nil when they are evenly spaced. powerline is the
+// mains frequency notched out of the ECG, 0 to disable the notch.
+func NewWindow(signal, times []float64, fs, powerline float64) Window {
+	filtered := NewECGFilter(fs, powerline).FiltFilt(signal)
+	return Window{
+		Times:        times,
+		SamplingRate: fs,
+		Filtered:     filtered,
+		Quality:      AssessQuality(filtered, fs),
+	}
+}
+
+// RRIntervals computes the cleaned RR intervals, in seconds, of the window.
+//
+// R peaks are detected with the Pan–Tompkins detector, and the intervals are measured on the times
+// of the peaks so gaps in the window are accounted for. Ectopic beats, missed and extra detections
+// are then corrected. It returns ErrNotEnoughBeats when the window has fewer than MinRRIntervals.
+func (w Window) RRIntervals() (RRIntervalSet, RRCorrection, error) {
+	peaks := DetectRPeaks(w.Filtered, w.SamplingRate)
+	if len(peaks) < MinRRIntervals+1 {
+		return RRIntervalSet{}, RRCorrection{}, ErrNotEnoughBeats
+	}
+
+	rr := make([]float64, 0, len(peaks)-1)
+	for i := 1; i < len(peaks); i++ {
+		rr = append(rr, w.time(peaks[i])-w.time(peaks[i-1]))
+	}
+
+	cleaned, correction := CleanRRIntervals(rr)
+	return NewRRIntervalSet(cleaned), correction, nil
+}
+
+// Features computes the HRV feature vector of size n of the window, and the corrections of its RR
+// intervals. The vector is nil when more than maxCorrection of the RR intervals were corrected.
+func (w Window) Features(n int, maxCorrection float64, nonlinear NonlinearOptions) ([]float64, RRCorrection, error) {
+	rr, correction, err := w.RRIntervals()
+	if err != nil {
+		return nil, correction, err
+	}
+	if correction.Corrected > maxCorrection {
+		return nil, correction, nil
+	}
+
+	features, err := NewHRVFeatureWithOptions(rr, nonlinear).VectorOfSize(n)
+	return features, correction, err
+}
+
+// time returns the time of sample i in seconds.
+func (w Window) time(i int) float64 {
+	if w.Times != nil {
+		return w.Times[i]
+	}
+	return float64(i) / w.SamplingRate
+}
@@ -14,16 +14,26 @@ import (
 
 // SetupDatabase initializes and returns a *gorm.DB object representing a connection to the database.
 //
-// It reads the database configuration from the environment variables and establishes a connection to the database using the specified parameters.
-// The function automatically migrates the entity.SensorData struct to the database and returns the *gorm.DB object.
+// It opens the database with OpenDatabase and seeds it with the test data.
 func SetupDatabase() *gorm.DB {
+	db, err := OpenDatabase()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	var err error
+	// Seeding
+	InsertTestData(db)
 
-	errEnv := godotenv.Load(".env")
+	return db
+}
 
-	if errEnv != nil {
-		log.Fatal("Error loading .env")
+// OpenDatabase opens a connection to the database and migrates the entities, without seeding it.
+//
+// It reads the database configuration from the environment variables, loaded from .env, as SetupDatabase does.
+// Tools such as the recording importer use it to write to the database of the gateway.
+func OpenDatabase() (*gorm.DB, error) {
+	if err := godotenv.Load(".env"); err != nil {
+		return nil, fmt.Errorf("error loading .env: %w", err)
 	}
 
 	host := os.Getenv("DSN_HOST")
@@ -45,18 +55,13 @@ func SetupDatabase() *gorm.DB {
 
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
-		log.Fatalf("Error connecting to the database: %v", err)
+		return nil, fmt.Errorf("error connecting to the database: %w", err)
 	}
 
-	err = AutoMigrateAllEntities(db)
-	if err != nil {
-		log.Fatalf("Error migrating entities: %v", err)
+	if err := AutoMigrateAllEntities(db); err != nil {
+		return nil, fmt.Errorf("error migrating entities: %w", err)
 	}
-
-	// Seeding
-	InsertTestData(db)
-
-	return db
+	return db, nil
 }
 
 // AutoMigrateAllEntities migrates all entities to the database.
@@ -67,6 +72,7 @@ func AutoMigrateAllEntities(db *gorm.DB) error {
 		&entity.Patient{},
 		&entity.SleepData{},
 		&entity.SleepStage{},
+		&entity.ReferenceStage{},
 		&entity.SleepQuality{},
 	)
 	if err != nil {
@@ -28,7 +28,7 @@ type Patient struct {
 	BirthDate        time.Time `json:"birth_date,omitempty"`
 	RegistrationDate time.Time `json:"registration_date,omitempty"`
 	Address          string    `json:"address,omitempty"`
-	SensorToken      string    `json:"sensor_token,omitempty"`
+	SensorToken      string    `gorm:"index" json:"sensor_token,omitempty"`
 	StartSleepTime   time.Time `json:"start_sleep_time,omitempty"`
 	EndSleepTime     time.Time `json:"end_sleep_time,omitempty"`
 }
@@ -47,6 +47,8 @@ type SleepData struct {
 // ECG represents the ECG table
 type ECG struct {
 	ID          uint      `gorm:"primaryKey" json:"id"`
+	PatientID   uint      `gorm:"index" json:"patient_id,omitempty"`
+	SleepDataID uint      `gorm:"index" json:"sleep_data_id,omitempty"`
 	ReferenceID uint      `json:"reference_id,omitempty"`
 	Value       float64   `json:"value,omitempty"`
 	InputTime   time.Time `json:"input_time,omitempty"`
@@ -54,10 +56,26 @@ type ECG struct {
 
 // SleepStage represents the Sleep Stage table
 type SleepStage struct {
-	ID          uint   `gorm:"primaryKey" json:"id"`
-	ReferenceID uint   `json:"reference_id,omitempty"`
-	Value       string `json:"value,omitempty"`
-	Method      string `json:"method,omitempty"`
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	PatientID   uint      `gorm:"index" json:"patient_id,omitempty"`
+	SleepDataID uint      `gorm:"index" json:"sleep_data_id,omitempty"`
+	ReferenceID uint      `json:"reference_id,omitempty"`
+	Value       string    `json:"value,omitempty"`
+	Method      string    `json:"method,omitempty"`
+	StartTime   time.Time `json:"start_time,omitempty"`
+	EndTime     time.Time `json:"end_time,omitempty"`
+}
+
+// ReferenceStage represents the Reference Stage table: a sleep stage scored by an expert on an epoch of
+// imported sleep data, against which the predicted sleep stages are validated
+type ReferenceStage struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	PatientID   uint      `gorm:"index" json:"patient_id,omitempty"`
+	SleepDataID uint      `gorm:"index" json:"sleep_data_id,omitempty"`
+	Value       string    `json:"value,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	StartTime   time.Time `json:"start_time,omitempty"`
+	EndTime     time.Time `json:"end_time,omitempty"`
 }
 
 // SleepQuality represents the Sleep Quality table
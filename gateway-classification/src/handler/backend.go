@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/classify"
+)
+
+// Classifier backends, selected with CLASSIFIER_BACKEND. The name of the backend that made a prediction
+// is stored as the Method of the sleep stage, followed by @ and the model version for versioned local models.
+const (
+	BackendLocal    = "local"
+	BackendRemote   = "remote"
+	BackendFallback = "fallback"
+)
+
+// MethodSignalQuality is the Method of the sleep stages of windows rejected before classification.
+const MethodSignalQuality = "signal-quality"
+
+// MethodClassifierError is the Method of the sleep stages of windows the classifier failed on.
+const MethodClassifierError = "classifier-error"
+
+// Classifier predicts the sleep stage of a window of ECG data.
+type Classifier interface {
+	// Classify predicts the sleep stage of the window and sets the Method of the prediction.
+	Classify(window ECGWindow) (PredictionResponse, error)
+}
+
+var activeClassifier Classifier
+
+// RemoteClassifier predicts sleep stages with the prediction service, posting it the samples of a window as JSON.
+type RemoteClassifier struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewRemoteClassifier creates a classifier for the prediction service at url, giving up on a request after timeout.
+func NewRemoteClassifier(url string, timeout time.Duration) *RemoteClassifier {
+	return &RemoteClassifier{
+		URL:    url,
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Classify posts the samples of the window to the prediction service and returns its prediction.
+func (c *RemoteClassifier) Classify(window ECGWindow) (PredictionResponse, error) {
+	if c.URL == "" {
+		return PredictionResponse{}, errors.New("PREDICT_URL environment variable is not set")
+	}
+
+	// Convert the window's samples to JSON
+	jsonData, err := json.Marshal(window.Data)
+	if err != nil {
+		return PredictionResponse{}, err
+	}
+
+	resp, err := c.Client.Post(c.URL, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return PredictionResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return PredictionResponse{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var predictionResponse PredictionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&predictionResponse); err != nil {
+		return PredictionResponse{}, err
+	}
+
+	predictionResponse.Labels = stageLabels
+	predictionResponse.Method = BackendRemote
+	return predictionResponse, nil
+}
+
+// FallbackClassifier tries its backends in order until one predicts the window.
+//
+// A backend that fails is skipped for Cooldown, so a slow or unreachable service does not delay every
+// window. The last backend is never skipped. Windows with too few beats are not retried, since no
+// backend can classify them.
+type FallbackClassifier struct {
+	Backends []Classifier
+	Cooldown time.Duration
+
+	mu        sync.Mutex
+	skipUntil []time.Time
+}
+
+// NewFallbackClassifier creates a classifier trying the backends in the given order.
+func NewFallbackClassifier(cooldown time.Duration, backends ...Classifier) *FallbackClassifier {
+	return &FallbackClassifier{
+		Backends:  backends,
+		Cooldown:  cooldown,
+		skipUntil: make([]time.Time, len(backends)),
+	}
+}
+
+// Classify returns the prediction of the first backend that succeeds.
+func (c *FallbackClassifier) Classify(window ECGWindow) (PredictionResponse, error) {
+	var lastErr error
+	for i, backend := range c.Backends {
+		last := i == len(c.Backends)-1
+
+		c.mu.Lock()
+		skip := !last && time.Now().Before(c.skipUntil[i])
+		c.mu.Unlock()
+		if skip {
+			continue
+		}
+
+		prediction, err := backend.Classify(window)
+		if err == nil || errors.Is(err, classify.ErrNotEnoughBeats) {
+			return prediction, err
+		}
+
+		fmt.Printf("FallbackClassifier: %T failed, skipping it for %s: %v\n", backend, c.Cooldown, err)
+		c.mu.Lock()
+		c.skipUntil[i] = time.Now().Add(c.Cooldown)
+		c.mu.Unlock()
+		lastErr = err
+	}
+
+	return PredictionResponse{}, fmt.Errorf("every classifier backend failed: %w", lastErr)
+}
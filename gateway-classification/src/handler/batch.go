@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/entity"
+)
+
+// SensorBatch is the payload of the "save-batch" event, carrying a frame of consecutive ECG samples.
+//
+// SensorID is the token of the sensor, as registered in entity.Patient.SensorToken.
+// StartTime is the device timestamp of the first sample; the following samples are
+// 1/SamplingRate seconds apart.
+type SensorBatch struct {
+	SensorID     string    `mapstructure:"sensor_id"`
+	SamplingRate float64   `mapstructure:"sampling_rate"`
+	StartTime    time.Time `mapstructure:"start_time"`
+	Samples      []float64 `mapstructure:"samples"`
+}
+
+// Validate checks that the batch can be expanded into timestamped samples.
+func (b SensorBatch) Validate() error {
+	if b.SensorID == "" {
+		return errors.New("missing sensor_id")
+	}
+	if b.SamplingRate <= 0 {
+		return errors.New("sampling_rate must be positive")
+	}
+	if b.StartTime.IsZero() {
+		return errors.New("missing start_time")
+	}
+	if len(b.Samples) == 0 {
+		return errors.New("no samples")
+	}
+	return nil
+}
+
+// ECG expands the batch into ECG samples with their own input time.
+func (b SensorBatch) ECG() []entity.ECG {
+	period := float64(time.Second) / b.SamplingRate
+
+	samples := make([]entity.ECG, len(b.Samples))
+	for i, value := range b.Samples {
+		samples[i] = entity.ECG{
+			Value:     value,
+			InputTime: b.StartTime.Add(time.Duration(float64(i) * period)),
+		}
+	}
+	return samples
+}
+
+// SaveBatch saves a frame of ECG samples sent with the "save-batch" event.
+//
+// The sensor ID is resolved to its patient, the samples are expanded into per-sample timestamps
+// starting at the batch start time, checked by the clock tracker as a single frame, and all of them
+// are queued for a bulk insert.
+func SaveBatch(sensorBatch SensorBatch) error {
+	patientID, err := ResolvePatient(sensorBatch.SensorID)
+	if err != nil {
+		return err
+	}
+
+	samples := Clocks.Adjust(sensorBatch.SensorID, sensorBatch.ECG(), time.Now())
+	if len(samples) == 0 {
+		return ErrSamplesDropped
+	}
+
+	if err := storeSamples(patientID, samples); err != nil {
+		return err
+	}
+
+	fmt.Printf("SaveBatch: %d samples queued for DB\n", len(samples))
+	return nil
+}
@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/classify"
+	"github.com/stanleydv12/gateway-classification/src/entity"
+)
+
+// UnscorableStage is the sleep stage stored for windows whose ECG is too noisy to be classified.
+const UnscorableStage = classify.UnscorableStage
+
+var (
+	// qualityThreshold is the signal quality score below which a window is unscorable.
+	qualityThreshold = classify.DefaultQualityThreshold
+	// maxRRCorrection is the fraction of corrected RR intervals above which a window is unscorable.
+	maxRRCorrection = 0.2
+)
+
+// nonlinearOptions configures the complexity features of models consuming the extended feature vector.
+var nonlinearOptions = classify.DefaultNonlinearOptions
+
+// stageLabels are the sleep stages of the prediction service, in the order of the probabilities of its predictions.
+var stageLabels = classify.DefaultSleepStageLabels
+
+// powerlineHz is the mains frequency removed from ECG windows before R-peak detection, 0 to disable the notch.
+var powerlineHz = 50.0
+
+// SetupClassifier configures the preprocessing of ECG windows and selects the classifier backend.
+//
+// CLASSIFIER_BACKEND is local, remote or fallback. local is the ELM model loaded from the newest bundle
+// in ELM_MODEL_DIR, from the bundle at ELM_MODEL_PATH, or from the CSV weights at ELM_INPUT_WEIGHT_PATH
+// and ELM_OUTPUT_WEIGHT_PATH. Bundles are reloaded when they change, checked every MODEL_POLL_INTERVAL,
+// 30s by default, and on the "model-reload" event. remote is the service at PREDICT_URL, called with a PREDICT_TIMEOUT of 5s
+// by default, and fallback tries remote first and local when it fails. After a failure, remote is skipped
+// for CLASSIFIER_FALLBACK_COOLDOWN, 30s by default. Without CLASSIFIER_BACKEND, fallback is used when
+// both are configured, local when only the model is, and remote otherwise.
+//
+// For CSV weights, ELM_ACTIVATION is the activation of the hidden layer and defaults to sigmoid.
+// SLEEP_STAGE_LABELS is the comma separated list of labels of the output nodes and defaults to
+// AWAKE,N1,N2,N3,REM; a bundle carries its own activation, labels and feature scaler.
+//
+// POWERLINE_HZ is the mains frequency notched out of the ECG before R-peak detection, 50 or 60,
+// and defaults to 50. 0 disables the notch.
+//
+// Windows are stored as UNSCORABLE when their signal quality score is below SQI_THRESHOLD, 0.8 by default,
+// or when more than MAX_RR_CORRECTION of their RR intervals were corrected, 0.2 by default.
+//
+// Models with 22 inputs also get the complexity features. ENTROPY_DIMENSION and ENTROPY_TOLERANCE are the
+// embedding dimension and the tolerance, relative to the SD of the RR intervals, of sample and approximate
+// entropy, and default to 2 and 0.2.
+//
+// Sessions are scored in epochs of EPOCH_LENGTH, 30s by default. EPOCH_CONTEXT is the duration of the
+// window centred on each epoch its features are computed on, and defaults to the epoch alone.
+func SetupClassifier() {
+	epochConfig.Length = durationFromEnv("EPOCH_LENGTH", epochConfig.Length)
+	epochConfig.Context = durationFromEnv("EPOCH_CONTEXT", epochConfig.Context)
+	powerlineHz = floatFromEnv("POWERLINE_HZ", powerlineHz)
+	qualityThreshold = floatFromEnv("SQI_THRESHOLD", qualityThreshold)
+	maxRRCorrection = floatFromEnv("MAX_RR_CORRECTION", maxRRCorrection)
+	nonlinearOptions.EmbeddingDimension = intFromEnv("ENTROPY_DIMENSION", nonlinearOptions.EmbeddingDimension)
+	nonlinearOptions.Tolerance = floatFromEnv("ENTROPY_TOLERANCE", nonlinearOptions.Tolerance)
+
+	local, err := loadLocalClassifier()
+	if err != nil {
+		log.Fatalf("Error loading ELM model: %v", err)
+	}
+	stageLabels = sleepStageLabels()
+	smoothingLabels := stageLabels
+	if local != nil {
+		smoothingLabels = local.Model().ELM.Labels
+		localModels = local
+		watchModels(local, durationFromEnv("MODEL_POLL_INTERVAL", 30*time.Second))
+	}
+
+	predictURL := os.Getenv("PREDICT_URL")
+	remote := NewRemoteClassifier(predictURL, durationFromEnv("PREDICT_TIMEOUT", 5*time.Second))
+
+	backend := os.Getenv("CLASSIFIER_BACKEND")
+	if backend == "" {
+		switch {
+		case local != nil && predictURL != "":
+			backend = BackendFallback
+		case local != nil:
+			backend = BackendLocal
+		default:
+			backend = BackendRemote
+		}
+	}
+
+	switch backend {
+	case BackendLocal:
+		if local == nil {
+			log.Fatalf("CLASSIFIER_BACKEND %s needs ELM_MODEL_DIR, ELM_MODEL_PATH, or ELM_INPUT_WEIGHT_PATH and ELM_OUTPUT_WEIGHT_PATH", backend)
+		}
+		activeClassifier = local
+	case BackendRemote:
+		if predictURL == "" {
+			fmt.Println("PREDICT_URL is not set, sleep stages can not be predicted")
+		}
+		activeClassifier = remote
+	case BackendFallback:
+		if local == nil {
+			log.Fatalf("CLASSIFIER_BACKEND %s needs ELM_MODEL_DIR, ELM_MODEL_PATH, or ELM_INPUT_WEIGHT_PATH and ELM_OUTPUT_WEIGHT_PATH", backend)
+		}
+		activeClassifier = NewFallbackClassifier(durationFromEnv("CLASSIFIER_FALLBACK_COOLDOWN", 30*time.Second), remote, local)
+	default:
+		log.Fatalf("Unknown CLASSIFIER_BACKEND %q, expected local, remote or fallback", backend)
+	}
+
+	fmt.Println("Classifying sleep stages with the", backend, "backend")
+
+	setupSmoothing(smoothingLabels)
+}
+
+// sleepStageLabels returns the labels of SLEEP_STAGE_LABELS, or the default ones.
+func sleepStageLabels() []string {
+	value := os.Getenv("SLEEP_STAGE_LABELS")
+	if value == "" {
+		return classify.DefaultSleepStageLabels
+	}
+
+	labels := strings.Split(value, ",")
+	for i := range labels {
+		labels[i] = strings.TrimSpace(labels[i])
+	}
+	return labels
+}
+
+// ECGWindow is a window of ECG data prepared for classification.
+type ECGWindow struct {
+	Data []entity.ECG
+	// Window is the filtered ECG, its signal quality and the sampling rate, in Hz, estimated from the input times.
+	classify.Window
+	// Baseline is the feature scaler fitted on the session, for models normalising against the patient's baseline.
+	Baseline *classify.Scaler
+	// Model is the local model the session is classified with, nil for the active model.
+	Model *LoadedModel
+}
+
+// NewECGWindow filters a window of ECG data and assesses its signal quality.
+//
+// RR intervals are measured on the input times of the samples, so gaps in the window are accounted for.
+func NewECGWindow(data []entity.ECG) (ECGWindow, error) {
+	fs := samplingRate(data)
+	if fs <= 0 {
+		return ECGWindow{}, classify.ErrNotEnoughBeats
+	}
+
+	signal := make([]float64, len(data))
+	times := make([]float64, len(data))
+	for i, ecg := range data {
+		signal[i] = ecg.Value
+		times[i] = ecg.InputTime.Sub(data[0].InputTime).Seconds()
+	}
+
+	return ECGWindow{
+		Data:   data,
+		Window: classify.NewWindow(signal, times, fs, powerlineHz),
+	}, nil
+}
+
+// samplingRate estimates the sampling rate, in Hz, of a window of ECG data from the median interval
+// between its input times. It returns 0 when the input times do not increase.
+func samplingRate(data []entity.ECG) float64 {
+	var intervals []time.Duration
+	for i := 1; i < len(data); i++ {
+		if interval := data[i].InputTime.Sub(data[i-1].InputTime); interval > 0 {
+			intervals = append(intervals, interval)
+		}
+	}
+	if len(intervals) == 0 {
+		return 0
+	}
+
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	return 1 / intervals[len(intervals)/2].Seconds()
+}
+
+// floatFromEnv reads a non-negative number from the given environment variable, falling back to def.
+func floatFromEnv(key string, def float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		fmt.Printf("Invalid %s %q, using %g\n", key, value, def)
+		return def
+	}
+	return f
+}
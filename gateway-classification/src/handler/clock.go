@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/entity"
+)
+
+// TimingPolicy is the action taken on a late, future-dated or out-of-order frame of samples.
+type TimingPolicy int
+
+const (
+	// PolicyAccept stores the samples with their device timestamps.
+	PolicyAccept TimingPolicy = iota
+	// PolicyCorrect moves the samples right after the last stored sample of the sensor, keeping the
+	// spacing of their device timestamps.
+	PolicyCorrect
+	// PolicyDrop discards the samples.
+	PolicyDrop
+)
+
+func (p TimingPolicy) String() string {
+	switch p {
+	case PolicyAccept:
+		return "accept"
+	case PolicyCorrect:
+		return "correct"
+	case PolicyDrop:
+		return "drop"
+	}
+	return fmt.Sprintf("TimingPolicy(%d)", int(p))
+}
+
+// ParseTimingPolicy parses "accept", "correct" or "drop".
+func ParseTimingPolicy(s string) (TimingPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "accept":
+		return PolicyAccept, nil
+	case "correct":
+		return PolicyCorrect, nil
+	case "drop":
+		return PolicyDrop, nil
+	}
+	return PolicyAccept, fmt.Errorf("unknown timing policy %q", s)
+}
+
+// TimingConfig configures how sample timestamps are checked.
+type TimingConfig struct {
+	// MaxLateness is how much longer than usual a frame may take to arrive before it is late.
+	MaxLateness time.Duration
+	// MaxFutureSkew is how far ahead of the gateway clock a device timestamp may be.
+	MaxFutureSkew time.Duration
+
+	Late       TimingPolicy
+	Future     TimingPolicy
+	OutOfOrder TimingPolicy
+}
+
+// PolicyCounts counts the frames handled with each action.
+type PolicyCounts struct {
+	Accepted  uint64 `json:"accepted"`
+	Corrected uint64 `json:"corrected"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// TimingStats counts the late, future-dated and out-of-order frames.
+type TimingStats struct {
+	Late       PolicyCounts `json:"late"`
+	Future     PolicyCounts `json:"future"`
+	OutOfOrder PolicyCounts `json:"out_of_order"`
+}
+
+func (c *PolicyCounts) add(policy TimingPolicy) {
+	switch policy {
+	case PolicyAccept:
+		c.Accepted++
+	case PolicyCorrect:
+		c.Corrected++
+	case PolicyDrop:
+		c.Dropped++
+	}
+}
+
+// sensorClock is the timing state of a single sensor.
+type sensorClock struct {
+	offset        time.Duration
+	hasOffset     bool
+	lastInputTime time.Time
+	stats         TimingStats
+}
+
+// offsetSmoothing is the weight of a new observation when the clock offset drifts upwards.
+const offsetSmoothing = 0.05
+
+// ClockTracker keeps the device timestamps of the samples and estimates the clock offset of every sensor.
+//
+// The offset is the gateway time minus the device time at which a frame arrives. Since transport delay
+// is never negative, the estimate follows lower observations immediately and higher ones slowly, so it
+// converges to the clock difference plus the usual transport delay.
+type ClockTracker struct {
+	config TimingConfig
+
+	mu      sync.Mutex
+	sensors map[string]*sensorClock
+}
+
+// Clocks is the clock tracker used by the event handlers.
+var Clocks *ClockTracker
+
+// NewClockTracker creates a clock tracker with the given configuration.
+func NewClockTracker(config TimingConfig) *ClockTracker {
+	return &ClockTracker{
+		config:  config,
+		sensors: map[string]*sensorClock{},
+	}
+}
+
+// SetupClocks creates the clock tracker from the environment variables.
+//
+// SAMPLE_MAX_LATENESS and SAMPLE_MAX_FUTURE_SKEW are Go durations and default to 30s and 5s.
+// LATE_SAMPLE_POLICY, FUTURE_SAMPLE_POLICY and OUT_OF_ORDER_SAMPLE_POLICY are one of accept,
+// correct or drop and default to drop, correct and accept.
+func SetupClocks() {
+	Clocks = NewClockTracker(TimingConfig{
+		MaxLateness:   durationFromEnv("SAMPLE_MAX_LATENESS", 30*time.Second),
+		MaxFutureSkew: durationFromEnv("SAMPLE_MAX_FUTURE_SKEW", 5*time.Second),
+		Late:          policyFromEnv("LATE_SAMPLE_POLICY", PolicyDrop),
+		Future:        policyFromEnv("FUTURE_SAMPLE_POLICY", PolicyCorrect),
+		OutOfOrder:    policyFromEnv("OUT_OF_ORDER_SAMPLE_POLICY", PolicyAccept),
+	})
+}
+
+// Adjust checks the timestamps of a frame of samples from a sensor that arrived at the given time.
+//
+// Parameters:
+// - sensor: the token identifying the sensor.
+// - samples: the samples of the frame, ordered by their device timestamp.
+// - arrival: the gateway time at which the frame arrived.
+//
+// Returns: the samples to store, or nil if the frame is dropped.
+func (c *ClockTracker) Adjust(sensor string, samples []entity.ECG, arrival time.Time) []entity.ECG {
+	if len(samples) == 0 {
+		return samples
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clock, ok := c.sensors[sensor]
+	if !ok {
+		clock = &sensorClock{}
+		c.sensors[sensor] = clock
+	}
+
+	first := samples[0].InputTime
+	last := samples[len(samples)-1].InputTime
+
+	// The frame is checked against the offset estimated from the previous frames.
+	observed := arrival.Sub(last)
+	future := last.Sub(arrival) > c.config.MaxFutureSkew
+	late := clock.hasOffset && observed-clock.offset > c.config.MaxLateness
+	clock.observe(observed)
+
+	var shift time.Duration
+	correct := false
+
+	if future {
+		clock.stats.Future.add(c.config.Future)
+		switch c.config.Future {
+		case PolicyDrop:
+			return nil
+		case PolicyCorrect:
+			correct = true
+		}
+	}
+
+	if late {
+		clock.stats.Late.add(c.config.Late)
+		switch c.config.Late {
+		case PolicyDrop:
+			return nil
+		case PolicyCorrect:
+			correct = true
+		}
+	}
+
+	if correct {
+		shift = clock.correction(samples, arrival)
+	}
+
+	if !clock.lastInputTime.IsZero() && !first.Add(shift).After(clock.lastInputTime) {
+		clock.stats.OutOfOrder.add(c.config.OutOfOrder)
+		switch c.config.OutOfOrder {
+		case PolicyDrop:
+			return nil
+		case PolicyCorrect:
+			shift = clock.correction(samples, arrival)
+		}
+	}
+
+	if shift != 0 {
+		for i := range samples {
+			samples[i].InputTime = samples[i].InputTime.Add(shift)
+		}
+	}
+
+	if end := samples[len(samples)-1].InputTime; end.After(clock.lastInputTime) {
+		clock.lastInputTime = end
+	}
+
+	return samples
+}
+
+// observe updates the clock offset estimate with the offset observed on a frame.
+func (clock *sensorClock) observe(observed time.Duration) {
+	if !clock.hasOffset || observed < clock.offset {
+		clock.offset = observed
+		clock.hasOffset = true
+		return
+	}
+	clock.offset += time.Duration(offsetSmoothing * float64(observed-clock.offset))
+}
+
+// correction returns the shift that moves a late or future-dated frame right after the last stored
+// sample of the sensor, so that it stays in the time domain of the frames stored before it. The first
+// frame of a sensor is moved to end at its arrival.
+func (clock *sensorClock) correction(samples []entity.ECG, arrival time.Time) time.Duration {
+	if clock.lastInputTime.IsZero() {
+		return arrival.Sub(samples[len(samples)-1].InputTime)
+	}
+	return clock.lastInputTime.Add(samplePeriod(samples)).Sub(samples[0].InputTime)
+}
+
+// Offset returns the estimated clock offset of a sensor, i.e. gateway time minus device time.
+func (c *ClockTracker) Offset(sensor string) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clock, ok := c.sensors[sensor]
+	if !ok || !clock.hasOffset {
+		return 0, false
+	}
+	return clock.offset, true
+}
+
+// Stats returns the number of late, future-dated and out-of-order frames of a sensor handled with each action.
+func (c *ClockTracker) Stats(sensor string) (TimingStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clock, ok := c.sensors[sensor]
+	if !ok {
+		return TimingStats{}, false
+	}
+	return clock.stats, true
+}
+
+// samplePeriod returns the spacing of the samples in a frame, or a millisecond for a single sample.
+func samplePeriod(samples []entity.ECG) time.Duration {
+	if len(samples) < 2 {
+		return time.Millisecond
+	}
+	return samples[len(samples)-1].InputTime.Sub(samples[0].InputTime) / time.Duration(len(samples)-1)
+}
+
+// policyFromEnv reads a timing policy from the given environment variable, falling back to def.
+func policyFromEnv(key string, def TimingPolicy) TimingPolicy {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	policy, err := ParseTimingPolicy(value)
+	if err != nil {
+		fmt.Printf("Invalid %s %q, using %s\n", key, value, def)
+		return def
+	}
+	return policy
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/entity"
+)
+
+// EpochConfig configures the segmentation of a session into scoring epochs.
+type EpochConfig struct {
+	// Length is the duration of an epoch, 30s as in AASM scoring.
+	Length time.Duration
+	// Context is the duration of the window the features of an epoch are computed on, centred on the epoch.
+	// A context no longer than Length uses the epoch alone.
+	Context time.Duration
+}
+
+// Epoch is a scoring epoch of a session.
+type Epoch struct {
+	Start time.Time
+	End   time.Time
+	// Samples are the samples of the epoch.
+	Samples []entity.ECG
+	// Context are the samples of the context window of the epoch, the epoch included.
+	Context []entity.ECG
+}
+
+var epochConfig = EpochConfig{Length: 30 * time.Second}
+
+// SegmentEpochs groups samples sorted by input time into consecutive epochs starting at the first sample.
+//
+// Epochs are aligned on the first sample, so gaps in the recording do not shift the epochs after them.
+// Epochs without samples are left out.
+//
+// Parameters:
+// - data: the samples of a session, sorted by input time.
+// - config: the epoch length and context.
+//
+// Returns: the epochs holding samples, in order.
+func SegmentEpochs(data []entity.ECG, config EpochConfig) []Epoch {
+	if len(data) == 0 || config.Length <= 0 {
+		return nil
+	}
+
+	margin := (config.Context - config.Length) / 2
+	if margin < 0 {
+		margin = 0
+	}
+
+	origin := data[0].InputTime
+	var epochs []Epoch
+	contextStart := 0
+	for i := 0; i < len(data); {
+		index := data[i].InputTime.Sub(origin) / config.Length
+		start := origin.Add(index * config.Length)
+		end := start.Add(config.Length)
+
+		j := i
+		for j < len(data) && data[j].InputTime.Before(end) {
+			j++
+		}
+
+		for contextStart < i && data[contextStart].InputTime.Before(start.Add(-margin)) {
+			contextStart++
+		}
+		contextEnd := j
+		for contextEnd < len(data) && data[contextEnd].InputTime.Before(end.Add(margin)) {
+			contextEnd++
+		}
+
+		epochs = append(epochs, Epoch{
+			Start:   start,
+			End:     end,
+			Samples: data[i:j],
+			Context: data[contextStart:contextEnd],
+		})
+		i = j
+	}
+	return epochs
+}
@@ -1,161 +1,125 @@
 package handler
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
-	"os"
 	"time"
 
 	"github.com/mitchellh/mapstructure"
+	"github.com/stanleydv12/gateway-classification/src/classify"
 	"github.com/stanleydv12/gateway-classification/src/entity"
 	"gorm.io/gorm"
 )
 
-var saveTimer *time.Timer
+// ErrSamplesDropped is returned when the clock tracker drops samples because of their timestamps.
+var ErrSamplesDropped = errors.New("samples dropped by timing policy")
 
-// HandleEvent handles the given event and data.
-//
-// Parameters:
-// - event: a string representing the event to be handled.
-// - data: an interface{} containing the data related to the event.
-//
-// Returns: None.
-func HandleEvent(event string, data interface{}) {
-	switch event {
-	case "save-data":
-		sensorData, ok := convertToSensorData(data)
-		if !ok {
-			fmt.Println("HandleEvent: Failed to convert data to SensorData")
-			return
-		}
-		SaveData(sensorData)
-	default:
-		fmt.Println("HandleEvent: unknown event")
+// errNoECG is returned by classifySession when the session has no ECG data to classify.
+var errNoECG = errors.New("no ECG data")
+
+func init() {
+	Register("save-data", SaveData)
+	Register("save-batch", SaveBatch)
+}
+
+// SensorData is the payload of the "save-data" event sent by a sensor.
+type SensorData struct {
+	SensorToken string    `mapstructure:"sensor_token"`
+	Value       float64   `mapstructure:"value"`
+	InputTime   time.Time `mapstructure:"input_time"`
+}
+
+// Validate checks that the sample names its sensor.
+func (d SensorData) Validate() error {
+	if d.SensorToken == "" {
+		return errors.New("missing sensor_token")
 	}
+	return nil
 }
 
 // SaveData saves the provided data to the database if it meets certain conditions.
 //
-// The function takes in a data interface{} parameter, which should be of type entity.ECG.
-// It first checks if the data is of the correct type using type assertion.
-// If the data is not of type entity.ECG, the function prints an error message and returns.
-//
-// The function then parses the input timestamp of the data and checks if it is valid.
-// If there is an error in parsing the timestamp, the function prints an error message and returns.
-//
-// The function calculates the time difference between the current time and the input timestamp.
-// If the time difference is greater than 30 seconds, the function prints a message and returns.
-//
-// If the data is the first ECG for a patient, the function sets the ReferenceID to 0.
-// Otherwise, it retrieves the ReferenceID for the patient using the GetReferenceIDForPatient function.
+// The function takes in a SensorData parameter carrying the sensor token of the patient.
+// The token is resolved against entity.Patient.SensorToken; samples with an unknown token are rejected.
 //
-// The function creates a new instance of entity.ECG with the updated ReferenceID and other attributes.
+// The device-supplied input time is kept. It is checked by the clock tracker, which applies the
+// configured policy to late, future-dated and out-of-order samples and may drop the sample.
 //
-// The function then attempts to save the newSensorData to the database using the DB.Create function.
-// If there is an error in saving the data, the function prints an error message and returns.
+// The sample is then recorded in the patient's sleep session, which opens a new session if the patient
+// has none. The first ECG of a session has a ReferenceID of 0; the following ones reference it.
 //
-// Finally, if the data is successfully saved to the database, the function prints a success message.
-func SaveData(data interface{}) {
-	fmt.Println(data)
-	sensorData, ok := data.(entity.ECG)
-	if !ok {
-		fmt.Println("SaveData: Invalid data format")
-		return
-	}
-
-	// Note: Inject input time with current time
-	sensorData.InputTime = time.Now()
-	currentTime := time.Now()
+// It returns an error if the patient is unknown, the sample is dropped or it can not be queued.
+func SaveData(sensorData SensorData) error {
+	fmt.Println(sensorData)
 
-	diff := currentTime.Sub(sensorData.InputTime)
-	if diff.Seconds() > 30 {
-		fmt.Println("SaveData: Data is older than 30 seconds, skipping save to DB")
-		return
+	patientID, err := ResolvePatient(sensorData.SensorToken)
+	if err != nil {
+		return err
 	}
 
-	if isFirstEcg := CheckIfFirstECG(sensorData); isFirstEcg {
-		sensorData.ReferenceID = 0
-
-		newSensorData := entity.ECG{
-			ReferenceID: sensorData.ReferenceID,
-			Value:       sensorData.Value,
-			InputTime:   sensorData.InputTime,
-		}
-
-		err := DB.Create(&newSensorData).Error
-		if err != nil {
-			fmt.Println("SaveData: Failed to save data to DB:", err)
-			return
-		}
-
-		firstSensorData := entity.ECG{}
-		DB.Select("id").First(&firstSensorData)
-
-		sleepData := entity.SleepData{
-			ID:             1,
-			PatientID:      1,
-			FirstECGID:     firstSensorData.ID,
-			FirstInputTime: firstSensorData.InputTime,
-		}
+	// Note: Samples without a device timestamp are stamped with their arrival time
+	arrival := time.Now()
+	if sensorData.InputTime.IsZero() {
+		sensorData.InputTime = arrival
+	}
 
-		err = DB.Save(&sleepData).Error
-		if err != nil {
-			fmt.Println("SaveData: Failed to save sleep data to DB:", err)
-			return
-		}
+	samples := []entity.ECG{{
+		PatientID: patientID,
+		Value:     sensorData.Value,
+		InputTime: sensorData.InputTime,
+	}}
+	samples = Clocks.Adjust(sensorData.SensorToken, samples, arrival)
+	if len(samples) == 0 {
+		return ErrSamplesDropped
+	}
 
-	} else {
-		sensorData.ReferenceID = GetReferenceIDForPatient(sensorData)
+	if err := storeSamples(patientID, samples); err != nil {
+		return err
+	}
 
-		newSensorData := entity.ECG{
-			ReferenceID: sensorData.ReferenceID,
-			Value:       sensorData.Value,
-			InputTime:   sensorData.InputTime,
-		}
+	fmt.Println("SaveData: Data queued for DB")
+	return nil
+}
 
-		err := DB.Create(&newSensorData).Error
-		if err != nil {
-			fmt.Println("SaveData: Failed to save data to DB:", err)
-			return
-		}
+// storeSamples saves ECG samples of a patient, ordered by input time, in the patient's sleep session.
+//
+// The samples are recorded in the patient's session, which opens a new session if the patient has none.
+// They are then appended to the write-ahead log, from which the replayer writes them to the database.
+func storeSamples(patientID uint, samples []entity.ECG) error {
+	if len(samples) == 0 {
+		return nil
+	}
 
-		sleepData := entity.SleepData{
-			ID:            1,
-			PatientID:     1,
-			LastInputTime: sensorData.InputTime,
-		}
+	session, err := Sessions.Record(patientID, samples[0].InputTime, samples[len(samples)-1].InputTime)
+	if err != nil {
+		return fmt.Errorf("failed to open session: %w", err)
+	}
 
-		err = DB.Save(&sleepData).Error
-		if err != nil {
-			fmt.Println("SaveData: Failed to save sleep data to DB:", err)
-			return
-		}
+	for i := range samples {
+		samples[i].PatientID = patientID
+		samples[i].SleepDataID = session.ID
 	}
 
-	// Reset the timer to 30 seconds
-	if saveTimer == nil {
-		saveTimer = time.NewTimer(30 * time.Second)
-	} else {
-		saveTimer.Reset(30 * time.Second)
+	if err := appendToIngestLog(samples); err != nil {
+		return fmt.Errorf("failed to write data to write-ahead log: %w", err)
 	}
 
-	fmt.Println("SaveData: Data saved to DB")
+	return nil
 }
 
-// convertToSensorData converts the given data to a SensorData object.
+// decodePayload decodes the data of an MQTT message into the given struct.
 //
-// data: The data to be converted.
-// Returns: The converted SensorData object and a boolean indicating if the conversion was successful.
-func convertToSensorData(data interface{}) (entity.ECG, bool) {
-	var sensorData entity.ECG
-	err := mapstructure.Decode(data, &sensorData)
+// Timestamps are expected as RFC 3339 strings.
+func decodePayload(data interface{}, out interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeHookFunc(time.RFC3339Nano),
+		WeaklyTypedInput: true,
+		Result:           out,
+	})
 	if err != nil {
-		return entity.ECG{}, false
+		return err
 	}
-	return sensorData, true
+	return decoder.Decode(data)
 }
 
 var DB *gorm.DB
@@ -167,187 +131,110 @@ func SetDBInstance(db *gorm.DB) {
 	DB = db
 }
 
-// CheckIfFirstECG checks if the given ECG sensor data is the first data recorded for a given date.
-//
-// It takes an ECG object as a parameter and returns a boolean value indicating whether the data is the first for that date.
-func CheckIfFirstECG(sensorData entity.ECG) bool {
-	var count int64
-	var lastData entity.ECG
-
-	// Check if data is empty
-	DB.Model(&entity.ECG{}).Count(&count)
-	if count == 0 {
-		return true
-	}
-
-	// Check if the last data for reference_id is not empty
-	DB.Order("input_time DESC").Where("reference_id = ?", sensorData.ReferenceID).First(&lastData)
-	if lastData.ID != 0 {
-		// Check if input_time is more than 30 seconds from the current time
-		currentTime := time.Now()
-		timeDiff := currentTime.Sub(lastData.InputTime)
-		if timeDiff.Seconds() > 30 {
-			return true
-		}
-	}
-
-	return false
-}
-
-// GetReferenceIDForPatient retrieves the reference ID for a patient based on the given ECG sensor data.
-//
-// It takes the following parameter:
-// - sensorData: an instance of the entity.ECG struct representing the ECG sensor data.
-//
-// It returns a uint representing the reference ID.
-func GetReferenceIDForPatient(sensorData entity.ECG) uint {
-	var referenceID uint
-	DB.Model(&entity.ECG{}).Where("DATE(input_time) = ?", sensorData.InputTime.Format("2006-01-02")).Select("id").Order("id ASC").First(&referenceID)
-	return referenceID
-}
-
-// StartTimer starts the timer for saving data to DB and calling classifyData
-func StartTimer() {
-	saveTimer = time.NewTimer(30 * time.Second)
-
-	go func() {
-		for {
-			select {
-			case <-saveTimer.C:
-				classifyData()
-				saveTimer.Reset(30 * time.Second)
-			}
-		}
-	}()
-}
-
 type PredictionResponse struct {
 	Prediction string `json:"prediction"`
+	// Probabilities are the probabilities of every sleep stage label, when the backend provides them.
+	Probabilities []float64 `json:"probabilities,omitempty"`
+	// Labels are the sleep stages of the model that made the prediction, in the order of the Probabilities.
+	Labels []string `json:"-"`
+	// Method is the backend that made the prediction, stored in SleepStage.Method.
+	Method string `json:"-"`
 }
 
-func classifyData() {
-
-	var lastECG entity.ECG
-	if err := DB.Order("input_time desc").First(&lastECG).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			fmt.Println("No ecg data found in the database")
-		} else {
-			log.Fatalf("Failed to get last ecg data: %v", err)
+// classifySession classifies the ECG data of a closed session and saves the resulting sleep stages,
+// one per scoring epoch with its start and end time. Epochs with too few beats, and epochs the
+// classifier fails on, are UNSCORABLE.
+// It returns errNoECG when the session has no ECG data.
+//
+// The whole session is classified with the local model active when it starts, even if another one is
+// loaded meanwhile. For models normalising against the patient's baseline, the baseline is fitted on
+// the epochs first. When a stage transition matrix is configured, the hypnogram is smoothed before it
+// is saved. The sleep stages are saved in a single transaction, so a failure saves none of them.
+func classifySession(session Session) error {
+	var allECG []entity.ECG
+	if err := DB.Where("sleep_data_id = ?", session.ID).Order("input_time asc").Find(&allECG).Error; err != nil {
+		return fmt.Errorf("failed to get ECG data: %w", err)
+	}
+	if len(allECG) == 0 {
+		return errNoECG
+	}
+
+	sleepData := entity.SleepData{ID: session.ID}
+	if err := DB.Model(&sleepData).Update("last_input_time", allECG[len(allECG)-1].InputTime).Error; err != nil {
+		return fmt.Errorf("failed to save sleep data: %w", err)
+	}
+
+	var model *LoadedModel
+	if localModels != nil {
+		model = localModels.Model()
+	}
+
+	epochs := SegmentEpochs(allECG, epochConfig)
+	baseline := sessionBaseline(model, epochs)
+	predictions := make([]PredictionResponse, len(epochs))
+	for i, epoch := range epochs {
+		prediction, err := predict(epoch.Context, model, baseline)
+		if errors.Is(err, classify.ErrNotEnoughBeats) {
+			fmt.Printf("Session %d: epoch at %s is unscorable: %v\n", session.ID, epoch.Start, err)
+			prediction = PredictionResponse{Prediction: UnscorableStage, Method: MethodSignalQuality}
+		} else if err != nil {
+			fmt.Printf("Session %d: failed to classify epoch at %s: %v\n", session.ID, epoch.Start, err)
+			prediction = PredictionResponse{Prediction: UnscorableStage, Method: MethodClassifierError}
 		}
-		return
+		predictions[i] = prediction
 	}
 
-	sleepData := entity.SleepData{
-		ID:            1,
-		LastInputTime: lastECG.InputTime,
-	}
-	if err := DB.Save(&sleepData).Error; err != nil {
-		log.Fatalf("Failed to save sleep data: %v", err)
-		return
-	}
-	
-
-	// Mengambil data ECG berdasarkan ID
-	var ecgByID []entity.ECG
-	if err := DB.Where("id = ?", lastECG.ReferenceID).Find(&ecgByID).Error; err != nil {
-		log.Fatalf("Failed to get ECG data by ID: %v", err)
-		return
-	}
-
-	// Mengambil semua data ECG berdasarkan reference ID
-	var ecgByReferenceID []entity.ECG
-	if err := DB.Where("reference_id = ?", lastECG.ReferenceID).Find(&ecgByReferenceID).Error; err != nil {
-		log.Fatalf("Failed to get ECG data by reference ID: %v", err)
-		return
-	}
-
-	// Combine data retrieved by ID and reference ID
-	allECG := append(ecgByID, ecgByReferenceID...)
-
-	// Process data in batches of 10
-	for i := 0; i < len(allECG); i += 10 {
-		end := i + 10
-		if end > len(allECG) {
-			end = len(allECG)
+	if stageTransitions != nil {
+		if err := smoothStages(predictions); err != nil {
+			fmt.Printf("Session %d: failed to smooth sleep stages: %v\n", session.ID, err)
 		}
+	}
 
-		batch := allECG[i:end]
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var firstSleepStageID uint
 
-		// Call the function to handle the API call for this batch
-		prediction, err := predict(batch)
-		if err != nil {
-			log.Fatalf("Failed to make prediction: %v", err)
-			return
-		}
-
-		if i == 0 {
+		for i, epoch := range epochs {
+			prediction := predictions[i]
 			sleepStage := entity.SleepStage{
-				ID:          1,
-				ReferenceID: 0,
+				PatientID:   session.PatientID,
+				SleepDataID: session.ID,
+				ReferenceID: firstSleepStageID,
 				Value:       prediction.Prediction,
+				Method:      prediction.Method,
+				StartTime:   epoch.Start,
+				EndTime:     epoch.End,
 			}
 
-			err = DB.Save(&sleepStage).Error
-			if err != nil {
-				log.Fatalf("Failed to save sleep stage: %v", err)
-				return
-			}
-
-			var sleepData entity.SleepData
-			err = DB.Find(&sleepData, "id = ?", 1).First(&sleepData).Error
-			if err != nil {
-				log.Fatalf("Failed to get sleep data: %v", err)
-				return
+			if err := tx.Create(&sleepStage).Error; err != nil {
+				return fmt.Errorf("failed to save sleep stage: %w", err)
 			}
 
-			sleepData.FirstSleepStageID = sleepStage.ID
-		} else {
-			sleepStage := entity.SleepStage{
-				ReferenceID: 1,
-				Value:       prediction.Prediction,
-			}
+			if firstSleepStageID == 0 {
+				firstSleepStageID = sleepStage.ID
 
-			err = DB.Save(&sleepStage).Error
-			if err != nil {
-				log.Fatalf("Failed to save sleep stage: %v", err)
-				return
+				if err := tx.Model(&sleepData).Update("first_sleep_stage_id", firstSleepStageID).Error; err != nil {
+					return fmt.Errorf("failed to save sleep data: %w", err)
+				}
 			}
 		}
-	}
+		return nil
+	})
 }
 
-func predict(data []entity.ECG) (PredictionResponse, error) {
-	extractedValues := make([]float64, len(data))
-
-	for i, ecg := range data {
-		extractedValues[i] = ecg.Value
-	}
-
-	// Convert the extracted data slice to JSON
-	jsonData, err := json.Marshal(data)
+// predict predicts the sleep stage of a window of ECG data with the configured classifier backend.
+// Windows of poor signal quality are UNSCORABLE. model is the local model of the session, and baseline
+// its feature scaler, if any.
+func predict(data []entity.ECG, model *LoadedModel, baseline *classify.Scaler) (PredictionResponse, error) {
+	window, err := NewECGWindow(data)
 	if err != nil {
 		return PredictionResponse{}, err
 	}
-
-	predictURL := os.Getenv("PREDICT_URL")
-	if predictURL == "" {
-		return PredictionResponse{}, fmt.Errorf("PREDICT_URL environment variable is not set")
-	}
-
-	resp, err := http.Post(predictURL, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return PredictionResponse{}, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return PredictionResponse{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	var predictionResponse PredictionResponse
-	if err := json.NewDecoder(resp.Body).Decode(&predictionResponse); err != nil {
-		return PredictionResponse{}, err
+	window.Model = model
+	window.Baseline = baseline
+	if !window.Quality.Acceptable(qualityThreshold) {
+		fmt.Printf("Window at %s is unscorable: signal quality %+v\n", data[0].InputTime, window.Quality)
+		return PredictionResponse{Prediction: UnscorableStage, Method: MethodSignalQuality}, nil
 	}
 
-	return predictionResponse, nil
+	return activeClassifier.Classify(window)
 }
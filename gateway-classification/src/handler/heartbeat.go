@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Heartbeat is the payload of the "heartbeat" event a sensor sends periodically while it is powered on.
+type Heartbeat struct {
+	SensorToken string    `mapstructure:"sensor_token"`
+	Battery     float64   `mapstructure:"battery"`
+	Time        time.Time `mapstructure:"time"`
+}
+
+// Validate checks that the heartbeat names its sensor.
+func (h Heartbeat) Validate() error {
+	if h.SensorToken == "" {
+		return errors.New("missing sensor_token")
+	}
+	return nil
+}
+
+// DeviceStatus is the last known status of a sensor. It is the data of the "deviceStatus" event.
+type DeviceStatus struct {
+	SensorToken string `json:"sensor_token"`
+	// LastSeen is the gateway time of the last heartbeat, and DeviceTime the time the sensor sent in it.
+	LastSeen   time.Time `json:"last_seen"`
+	DeviceTime time.Time `json:"device_time,omitempty"`
+	Battery    float64   `json:"battery"`
+	// ClockOffset is the estimated gateway time minus device time of the sensor's samples, and Timing
+	// counts its late, future-dated and out-of-order frames. Both are set when the status is published.
+	ClockOffset string      `json:"clock_offset,omitempty"`
+	Timing      TimingStats `json:"timing"`
+}
+
+// DeviceStatusEvent is the event published in reply to a "device-status" request.
+const DeviceStatusEvent = "deviceStatus"
+
+// DeviceStatusRequest is the payload of the "device-status" event.
+type DeviceStatusRequest struct {
+	SensorToken string `mapstructure:"sensor_token"`
+}
+
+// Validate checks that the request names its sensor.
+func (r DeviceStatusRequest) Validate() error {
+	if r.SensorToken == "" {
+		return errors.New("missing sensor_token")
+	}
+	return nil
+}
+
+var (
+	devicesMu sync.RWMutex
+	devices   = map[string]DeviceStatus{}
+)
+
+// SaveHeartbeat records the heartbeat of a registered sensor.
+func SaveHeartbeat(heartbeat Heartbeat) error {
+	if _, err := ResolvePatient(heartbeat.SensorToken); err != nil {
+		return err
+	}
+
+	devicesMu.Lock()
+	devices[heartbeat.SensorToken] = DeviceStatus{
+		SensorToken: heartbeat.SensorToken,
+		LastSeen:    time.Now(),
+		DeviceTime:  heartbeat.Time,
+		Battery:     heartbeat.Battery,
+	}
+	devicesMu.Unlock()
+
+	return nil
+}
+
+// GetDeviceStatus returns the last known status of a sensor, and false if it never sent a heartbeat.
+func GetDeviceStatus(sensorToken string) (DeviceStatus, bool) {
+	devicesMu.RLock()
+	defer devicesMu.RUnlock()
+
+	status, ok := devices[sensorToken]
+	return status, ok
+}
+
+// PublishDeviceStatus publishes the last known status of a sensor as a "deviceStatus" event.
+//
+// Returns: an error if the sensor is unknown or never sent a heartbeat.
+func PublishDeviceStatus(request DeviceStatusRequest) error {
+	if _, err := ResolvePatient(request.SensorToken); err != nil {
+		return err
+	}
+
+	status, ok := GetDeviceStatus(request.SensorToken)
+	if !ok {
+		return &EventError{Code: CodeRejected, Err: errors.New("no heartbeat received from the sensor")}
+	}
+
+	if Clocks != nil {
+		if offset, ok := Clocks.Offset(request.SensorToken); ok {
+			status.ClockOffset = offset.String()
+		}
+		status.Timing, _ = Clocks.Stats(request.SensorToken)
+	}
+
+	go Publish(Message{Event: DeviceStatusEvent, Data: status})
+	return nil
+}
+
+func init() {
+	Register("heartbeat", SaveHeartbeat)
+	Register("device-status", PublishDeviceStatus)
+}
@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/entity"
+	"github.com/stanleydv12/gateway-classification/src/wal"
+)
+
+const (
+	minReplayBackoff = time.Second
+	maxReplayBackoff = 30 * time.Second
+)
+
+var (
+	ingestLog    *wal.Log
+	ingestWriter *ECGWriter
+)
+
+// SetupIngestLog opens the write-ahead log for ingested samples and starts replaying it into the database.
+//
+// WAL_DIR is the log directory and defaults to data/wal. WAL_MAX_SEGMENT_BYTES and WAL_MAX_TOTAL_BYTES
+// limit the size of a segment file and of the whole log, and default to 8 MiB and 512 MiB.
+// Samples already in the log from a previous run are replayed first.
+//
+// ECG_FLUSH_SIZE and ECG_FLUSH_INTERVAL set when buffered samples are written to the database,
+// and default to 1000 samples and 2s.
+func SetupIngestLog() {
+	dir := os.Getenv("WAL_DIR")
+	if dir == "" {
+		dir = "data/wal"
+	}
+
+	l, err := wal.Open(dir, wal.Options{
+		MaxSegmentBytes: bytesFromEnv("WAL_MAX_SEGMENT_BYTES", 8<<20),
+		MaxTotalBytes:   bytesFromEnv("WAL_MAX_TOTAL_BYTES", 512<<20),
+	})
+	if err != nil {
+		log.Fatalf("Error opening write-ahead log: %v", err)
+	}
+
+	if pending := l.Pending(); pending > 0 {
+		fmt.Printf("Replaying %d samples from write-ahead log\n", pending)
+	}
+
+	ingestLog = l
+	ingestWriter = NewECGWriter(
+		intFromEnv("ECG_FLUSH_SIZE", 1000),
+		durationFromEnv("ECG_FLUSH_INTERVAL", 2*time.Second),
+	)
+	go replayIngestLog(l, ingestWriter)
+}
+
+// appendToIngestLog appends ECG samples to the write-ahead log with a single sync.
+func appendToIngestLog(samples []entity.ECG) error {
+	records := make([][]byte, len(samples))
+	for i, sample := range samples {
+		record, err := json.Marshal(sample)
+		if err != nil {
+			return err
+		}
+		records[i] = record
+	}
+
+	_, err := ingestLog.AppendBatch(records)
+	return err
+}
+
+// replayIngestLog writes the samples of the write-ahead log to the database in order.
+//
+// Samples are buffered in an ECGWriter, which is flushed when it holds FlushSize samples or when
+// FlushInterval elapsed since the first buffered sample. The log is committed only after a flush
+// succeeded. While the database is unreachable the flush is retried with an increasing backoff,
+// so the samples stay in the log until the database is healthy again. A log that can not be read is
+// retried with the same backoff, and the rest of a segment holding a corrupted record is skipped.
+func replayIngestLog(l *wal.Log, writer *ECGWriter) {
+	reader := l.NewReader(l.Committed() + 1)
+	backoff := minReplayBackoff
+
+	lastSeq := l.Committed()
+	var flushDeadline <-chan time.Time
+	for {
+		caughtUp := false
+		for !writer.Full() {
+			record, err := reader.Next()
+			if err == io.EOF {
+				caughtUp = true
+				break
+			}
+			if errors.Is(err, wal.ErrInvalidRecord) {
+				next, skipErr := reader.Skip()
+				if skipErr != nil {
+					fmt.Printf("replayIngestLog: Failed to skip corrupted write-ahead log segment, retrying in %s: %v\n", backoff, skipErr)
+					backoff = replayBackoff(backoff)
+					continue
+				}
+				fmt.Printf("replayIngestLog: Skipping records %d to %d of a corrupted write-ahead log segment: %v\n", lastSeq+1, next-1, err)
+				lastSeq = next - 1
+				continue
+			}
+			if err != nil {
+				fmt.Printf("replayIngestLog: Failed to read write-ahead log, retrying in %s: %v\n", backoff, err)
+				backoff = replayBackoff(backoff)
+				continue
+			}
+
+			var sample entity.ECG
+			if err := json.Unmarshal(record.Data, &sample); err != nil {
+				fmt.Printf("replayIngestLog: Skipping undecodable record %d: %v\n", record.Seq, err)
+			} else {
+				if writer.Len() == 0 {
+					flushDeadline = time.After(writer.FlushInterval())
+				}
+				writer.Add(sample)
+			}
+			lastSeq = record.Seq
+		}
+
+		if caughtUp && !writer.Full() {
+			if writer.Len() == 0 {
+				if lastSeq > l.Committed() {
+					commitIngestLog(l, lastSeq)
+				}
+				flushDeadline = nil
+			}
+
+			select {
+			case <-l.Notify():
+				continue
+			case <-flushDeadline:
+			}
+		}
+
+		if err := writer.Flush(); err != nil {
+			fmt.Printf("replayIngestLog: Failed to save data to DB, retrying in %s: %v\n", backoff, err)
+			backoff = replayBackoff(backoff)
+			flushDeadline = time.After(0)
+			continue
+		}
+		backoff = minReplayBackoff
+		flushDeadline = nil
+
+		commitIngestLog(l, lastSeq)
+	}
+}
+
+// replayBackoff waits for backoff and returns the doubled backoff, up to maxReplayBackoff.
+func replayBackoff(backoff time.Duration) time.Duration {
+	time.Sleep(backoff)
+	return min(2*backoff, maxReplayBackoff)
+}
+
+func commitIngestLog(l *wal.Log, seq uint64) {
+	if err := l.Commit(seq); err != nil {
+		fmt.Println("replayIngestLog: Failed to commit write-ahead log:", err)
+	}
+}
+
+// waitForIngestLog waits until every sample appended so far has been written to the database.
+//
+// It returns false if the samples were not written within the timeout.
+func waitForIngestLog(timeout time.Duration) bool {
+	if ingestLog == nil {
+		return true
+	}
+
+	target := ingestLog.LastSeq()
+	deadline := time.Now().Add(timeout)
+	for ingestLog.Committed() < target {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// intFromEnv reads a positive integer from the given environment variable, falling back to def.
+func intFromEnv(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		fmt.Printf("Invalid %s %q, using %d\n", key, value, def)
+		return def
+	}
+	return n
+}
+
+// bytesFromEnv reads a size in bytes from the given environment variable, falling back to def.
+func bytesFromEnv(key string, def int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		fmt.Printf("Invalid %s %q, using %d\n", key, value, def)
+		return def
+	}
+	return n
+}
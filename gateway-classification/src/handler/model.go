@@ -0,0 +1,403 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/classify"
+)
+
+// LoadedModel is a model used by the local classifier.
+type LoadedModel struct {
+	ELM *classify.ELMClassifier
+	ModelStatus
+	modTime time.Time
+}
+
+// LocalClassifier predicts sleep stages on the gateway from the HRV features of a window with an ELM model.
+//
+// The model can be replaced while windows are classified. A session is classified entirely with the
+// model that was active when its classification started, given as the Model of its windows.
+type LocalClassifier struct {
+	model atomic.Pointer[LoadedModel]
+	// source is the file or directory bundles are reloaded from, empty for CSV weights.
+	source string
+	// reloadMu serialises reloads, so that the watcher and the reload event do not race.
+	reloadMu sync.Mutex
+}
+
+// localModels is the local classifier whose model can be reloaded, nil without one.
+var localModels *LocalClassifier
+
+// Model returns the active model.
+func (c *LocalClassifier) Model() *LoadedModel {
+	return c.model.Load()
+}
+
+// Classify predicts the sleep stage of the window. Windows whose RR intervals needed too many corrections are unscorable.
+// The Method of the prediction holds the version of the model, as local@version.
+//
+// The window is classified with its Model, or with the active model when it has none. Models normalising
+// the features against the patient's baseline use the Baseline of the window.
+func (c *LocalClassifier) Classify(window ECGWindow) (PredictionResponse, error) {
+	model := window.Model
+	if model == nil {
+		model = c.model.Load()
+	}
+	method := BackendLocal
+	if model.Version != "" {
+		method += "@" + model.Version
+	}
+
+	features, correction, err := modelFeatures(model, window)
+	if err != nil {
+		return PredictionResponse{}, err
+	}
+	if features == nil {
+		fmt.Printf("Window at %s is unscorable: %.0f%% of RR intervals corrected\n",
+			window.Data[0].InputTime, correction.Corrected*100)
+		return PredictionResponse{Prediction: UnscorableStage, Method: method}, nil
+	}
+
+	elm := model.ELM
+	if elm.NeedsBaseline() {
+		if window.Baseline == nil {
+			return PredictionResponse{}, fmt.Errorf("model %s needs the baseline of the session", model.Version)
+		}
+		if elm, err = elm.WithScaler(window.Baseline); err != nil {
+			return PredictionResponse{}, err
+		}
+	}
+
+	label, scores, err := elm.Classify(features)
+	if err != nil {
+		return PredictionResponse{}, err
+	}
+
+	return PredictionResponse{
+		Prediction:    label,
+		Probabilities: elm.Probabilities(scores),
+		Labels:        model.ELM.Labels,
+		Method:        method,
+	}, nil
+}
+
+// modelFeatures computes the feature vector of the window for the model, and the corrections of its
+// RR intervals. The vector is nil when too many RR intervals were corrected.
+func modelFeatures(model *LoadedModel, window ECGWindow) ([]float64, classify.RRCorrection, error) {
+	return window.Features(model.ELM.Features(), maxRRCorrection, nonlinearOptions)
+}
+
+// Reload loads the bundle at path, or the newest bundle of the classifier's source when path is empty,
+// and makes it the active model.
+//
+// The bundle is validated before it is swapped in, and must have the labels of the active model, which the
+// smoothing and quantification of sleep stages rely on. On error, the active model is kept.
+func (c *LocalClassifier) Reload(path string) (*LoadedModel, error) {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	if path == "" {
+		if c.source == "" {
+			return nil, errors.New("the model was loaded from CSV weights, a bundle path is needed")
+		}
+		newest, _, err := newestBundle(c.source)
+		if err != nil {
+			return nil, err
+		}
+		path = newest
+	}
+
+	model, err := loadBundle(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if current := c.model.Load(); current != nil && !equalLabels(current.ELM.Labels, model.ELM.Labels) {
+		return nil, fmt.Errorf("model %s has labels %v, the active model has %v", model.Version, model.ELM.Labels, current.ELM.Labels)
+	}
+
+	c.model.Store(model)
+	fmt.Printf("Loaded ELM model %s from %s with %d features and %d classes\n",
+		model.Version, model.Path, model.ELM.Features(), len(model.ELM.Labels))
+	go Publish(Message{Event: ModelLoadedEvent, Data: model.ModelStatus})
+	return model, nil
+}
+
+// loadLocalClassifier loads the newest ELM model bundle in ELM_MODEL_DIR, the bundle at ELM_MODEL_PATH,
+// or the legacy CSV weights at ELM_INPUT_WEIGHT_PATH and ELM_OUTPUT_WEIGHT_PATH. It returns nil when none is set.
+func loadLocalClassifier() (*LocalClassifier, error) {
+	source := os.Getenv("ELM_MODEL_DIR")
+	if source == "" {
+		source = os.Getenv("ELM_MODEL_PATH")
+	}
+	if source != "" {
+		c := &LocalClassifier{source: source}
+		if _, err := c.Reload(""); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	inputWeightPath := os.Getenv("ELM_INPUT_WEIGHT_PATH")
+	outputWeightPath := os.Getenv("ELM_OUTPUT_WEIGHT_PATH")
+	if inputWeightPath == "" || outputWeightPath == "" {
+		return nil, nil
+	}
+
+	model, err := classify.NewELMModel(inputWeightPath, outputWeightPath)
+	if err != nil {
+		return nil, err
+	}
+
+	activation := os.Getenv("ELM_ACTIVATION")
+	if activation == "" {
+		activation = "sigmoid"
+	}
+
+	labels := sleepStageLabels()
+	elm, err := classify.NewELMClassifier(model, activation, labels)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := new(classify.HRVFeature).VectorOfSize(elm.Features()); err != nil {
+		return nil, err
+	}
+
+	c := &LocalClassifier{}
+	c.model.Store(&LoadedModel{
+		ELM:         elm,
+		ModelStatus: ModelStatus{Path: inputWeightPath, LoadedAt: time.Now()},
+	})
+	fmt.Printf("Loaded local ELM model with %d features and %d classes\n", elm.Features(), len(labels))
+	return c, nil
+}
+
+// watchModels reloads the model of the classifier whenever the newest bundle of its source changes.
+//
+// A bundle is tried once per modification, so an invalid bundle is not retried until it is replaced,
+// and a model loaded with the "model-reload" event stays active until the source changes.
+func watchModels(c *LocalClassifier, interval time.Duration) {
+	if c.source == "" {
+		return
+	}
+
+	current := c.Model()
+	seenPath, seenTime := current.Path, current.modTime
+
+	go func() {
+		for range time.Tick(interval) {
+			path, modTime, err := newestBundle(c.source)
+			if err != nil {
+				fmt.Println("watchModels: Failed to look for model bundles:", err)
+				continue
+			}
+			if path == seenPath && modTime.Equal(seenTime) {
+				continue
+			}
+			seenPath, seenTime = path, modTime
+
+			if _, err := c.Reload(path); err != nil {
+				fmt.Println("watchModels: Keeping model", c.Model().Version+":", err)
+			}
+		}
+	}()
+}
+
+// loadBundle loads and validates the model bundle at path.
+func loadBundle(path string) (*LoadedModel, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle, err := classify.LoadModelBundle(path)
+	if err != nil {
+		return nil, err
+	}
+	elm, err := bundle.Classifier()
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoadedModel{
+		ELM: elm,
+		ModelStatus: ModelStatus{
+			Version:  bundle.Version,
+			Path:     path,
+			LoadedAt: time.Now(),
+		},
+		modTime: info.ModTime(),
+	}, nil
+}
+
+// newestBundle returns the bundle file at source, or the most recently modified .json file in it
+// when source is a directory, with its modification time.
+func newestBundle(source string) (string, time.Time, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !info.IsDir() {
+		return source, info.ModTime(), nil
+	}
+
+	entries, err := os.ReadDir(source)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	var newest string
+	var newestTime time.Time
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if newest == "" || info.ModTime().After(newestTime) {
+			newest = filepath.Join(source, entry.Name())
+			newestTime = info.ModTime()
+		}
+	}
+	if newest == "" {
+		return "", time.Time{}, fmt.Errorf("no model bundle in %s", source)
+	}
+	return newest, newestTime, nil
+}
+
+func equalLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sessionBaseline fits the feature scaler of a session on the features of its scorable epochs when the
+// local model of the session normalises against the patient's baseline. It returns nil otherwise, or when it fails.
+func sessionBaseline(model *LoadedModel, epochs []Epoch) *classify.Scaler {
+	if model == nil || !model.ELM.NeedsBaseline() {
+		return nil
+	}
+
+	var session [][]float64
+	for _, epoch := range epochs {
+		window, err := NewECGWindow(epoch.Context)
+		if err != nil || !window.Quality.Acceptable(qualityThreshold) {
+			continue
+		}
+		features, _, err := modelFeatures(model, window)
+		if err != nil || features == nil {
+			continue
+		}
+		session = append(session, features)
+	}
+
+	baseline, err := classify.FitScaler(session, classify.ScalerBaseline)
+	if err != nil {
+		fmt.Println("sessionBaseline: Failed to fit the baseline of the session:", err)
+		return nil
+	}
+	return baseline
+}
+
+// ModelReload is the data of the "model-reload" event.
+type ModelReload struct {
+	// Name is the file name of the bundle to load in ELM_MODEL_DIR. Empty reloads the newest bundle of
+	// ELM_MODEL_DIR, or the bundle at ELM_MODEL_PATH.
+	Name string `mapstructure:"name"`
+}
+
+// ReloadModel replaces the model of the local classifier.
+//
+// Parameters:
+// - reload: the bundle to load.
+//
+// Returns: an error if there is no local classifier, the name is not a bundle of the model directory or the
+// bundle is invalid, in which case the active model is kept.
+func ReloadModel(reload ModelReload) error {
+	if localModels == nil {
+		return &EventError{Code: CodeRejected, Err: errors.New("no local classifier configured")}
+	}
+
+	path, err := localModels.bundlePath(reload.Name)
+	if err != nil {
+		return &EventError{Code: CodeRejected, Err: err}
+	}
+	if _, err := localModels.Reload(path); err != nil {
+		return &EventError{Code: CodeRejected, Err: err}
+	}
+	return nil
+}
+
+// bundlePath returns the path of the bundle named name in the classifier's model directory, or an empty
+// path, reloading the newest bundle of the source, when name is empty.
+//
+// Only a file name is accepted, so that the event can not load files outside the model directory.
+func (c *LocalClassifier) bundlePath(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+
+	if c.source == "" {
+		return "", errors.New("the model was loaded from CSV weights, bundles can not be named")
+	}
+	info, err := os.Stat(c.source)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", errors.New("bundles can only be named with ELM_MODEL_DIR")
+	}
+
+	if filepath.IsAbs(name) || name != filepath.Base(filepath.Clean(name)) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid bundle name %q, expected a file name in the model directory", name)
+	}
+	if !strings.HasSuffix(name, ".json") {
+		return "", fmt.Errorf("invalid bundle name %q, expected a .json file", name)
+	}
+	return filepath.Join(c.source, name), nil
+}
+
+// ActiveModel returns the model of the local classifier, or false without one.
+func ActiveModel() (ModelStatus, bool) {
+	if localModels == nil {
+		return ModelStatus{}, false
+	}
+	return localModels.Model().ModelStatus, true
+}
+
+// ModelStatusRequest is the payload of the "model-status" event, which has no data.
+type ModelStatusRequest struct{}
+
+// PublishModelStatus publishes the model of the local classifier as a "modelStatus" event, so that the
+// model loaded at startup, before the broker was connected, can be audited too.
+//
+// Returns: an error if there is no local classifier.
+func PublishModelStatus(ModelStatusRequest) error {
+	status, ok := ActiveModel()
+	if !ok {
+		return &EventError{Code: CodeRejected, Err: errors.New("no local classifier configured")}
+	}
+
+	go Publish(Message{Event: ModelStatusEvent, Data: status})
+	return nil
+}
+
+func init() {
+	Register("model-reload", ReloadModel)
+	Register("model-status", PublishModelStatus)
+}
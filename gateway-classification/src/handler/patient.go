@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/entity"
+	"gorm.io/gorm"
+)
+
+// ErrUnknownSensorToken is returned when a sensor token does not belong to any registered patient.
+var ErrUnknownSensorToken = errors.New("unknown sensor token")
+
+// cachedPatient is a sensor token resolved to its patient.
+type cachedPatient struct {
+	patientID uint
+	expires   time.Time
+}
+
+var (
+	patientCacheMu sync.RWMutex
+	patientCache   = map[string]cachedPatient{}
+	// patientCacheTTL is how long a resolved sensor token is trusted before it is looked up again.
+	patientCacheTTL = time.Minute
+)
+
+// SetupPatients configures the resolution of sensor tokens.
+//
+// PATIENT_CACHE_TTL is a Go duration and defaults to 1m. A sensor re-assigned to another patient
+// is attributed to the new patient at the latest once the TTL elapsed.
+func SetupPatients() {
+	patientCacheTTL = durationFromEnv("PATIENT_CACHE_TTL", patientCacheTTL)
+}
+
+// ResolvePatient returns the ID of the patient that owns the given sensor token.
+//
+// Parameters:
+// - sensorToken: the token sent by the sensor together with its samples.
+//
+// Returns: the patient ID, or ErrUnknownSensorToken if no patient is registered with the token.
+func ResolvePatient(sensorToken string) (uint, error) {
+	if sensorToken == "" {
+		return 0, ErrUnknownSensorToken
+	}
+
+	patientCacheMu.RLock()
+	cached, ok := patientCache[sensorToken]
+	patientCacheMu.RUnlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.patientID, nil
+	}
+
+	var patient entity.Patient
+	err := DB.Select("id").Where("sensor_token = ?", sensorToken).Order("id ASC").First(&patient).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		patientCacheMu.Lock()
+		delete(patientCache, sensorToken)
+		patientCacheMu.Unlock()
+		return 0, ErrUnknownSensorToken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	patientCacheMu.Lock()
+	patientCache[sensorToken] = cachedPatient{patientID: patient.ID, expires: time.Now().Add(patientCacheTTL)}
+	patientCacheMu.Unlock()
+
+	return patient.ID, nil
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Message is an event published by the gateway.
+type Message struct {
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// SleepStageReadyEvent is the event telling quantification that the sleep stages of a session are stored.
+const SleepStageReadyEvent = "sleepStageReady"
+
+// SleepStageReady is the data of the "sleepStageReady" event, telling quantification which session to process.
+type SleepStageReady struct {
+	SessionID uint `json:"session_id"`
+	PatientID uint `json:"patient_id"`
+}
+
+// ModelLoadedEvent is the event published when the local classifier starts using a model, for audit.
+const ModelLoadedEvent = "modelLoaded"
+
+// ModelStatusEvent is the event published in reply to a "model-status" request.
+const ModelStatusEvent = "modelStatus"
+
+// ModelStatus identifies the model of the local classifier. It is the data of the "modelLoaded" and
+// "modelStatus" events.
+type ModelStatus struct {
+	// Version is the version of the model bundle, empty for CSV weights.
+	Version  string    `json:"version"`
+	Path     string    `json:"path"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+var publisher func(topic string, msg interface{})
+
+// SetPublisher sets the function used to publish messages to the broker.
+//
+// pub: the function publishing a payload to a topic, such as mqtt.PubTo. An empty topic is the default topic.
+func SetPublisher(pub func(topic string, msg interface{})) {
+	publisher = pub
+}
+
+// Publish encodes the message as JSON and publishes it to the default topic.
+func Publish(msg Message) {
+	PublishTo("", msg)
+}
+
+// PublishTo encodes the message as JSON and publishes it to the given topic with the configured publisher.
+func PublishTo(topic string, msg Message) {
+	if publisher == nil {
+		fmt.Println("Publish: no publisher set, dropping", msg.Event)
+		return
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		fmt.Println("Publish: Failed to encode message:", err)
+		return
+	}
+
+	publisher(topic, payload)
+}
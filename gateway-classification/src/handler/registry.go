@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Error codes sent in an ErrorReply.
+const (
+	CodeUnknownEvent   = "unknown_event"
+	CodeInvalidPayload = "invalid_payload"
+	CodeUnknownSensor  = "unknown_sensor"
+	CodeRejected       = "rejected"
+	CodeInternal       = "internal_error"
+)
+
+// ErrorEvent is the event of the replies published when an event can not be handled.
+const ErrorEvent = "error"
+
+// Validator is implemented by event payloads that check themselves after decoding.
+type Validator interface {
+	Validate() error
+}
+
+// EventError is an error of an event handler carrying the code sent back in the ErrorReply.
+type EventError struct {
+	Code string
+	Err  error
+}
+
+func (e *EventError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Code, e.Err)
+}
+
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorReply is the data of the "error" event published back to the sender of an event that failed.
+type ErrorReply struct {
+	Event     string `json:"event"`
+	RequestID string `json:"request_id,omitempty"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// Request is an event received from the broker.
+type Request struct {
+	Event string
+	// ID is an optional identifier chosen by the sender and echoed in the error reply.
+	ID string
+	// ReplyTo is the topic the error reply is published to.
+	ReplyTo string
+	Data    interface{}
+}
+
+type eventHandler func(data interface{}) error
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]eventHandler{}
+)
+
+// Register registers fn as the handler of an event.
+//
+// The data of the event is decoded into a T. If T implements Validator, the payload is validated
+// before fn is called. Decoding and validation errors are replied with CodeInvalidPayload.
+// Register panics if the event already has a handler.
+func Register[T any](event string, fn func(T) error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[event]; ok {
+		panic("handler: event registered twice: " + event)
+	}
+
+	registry[event] = func(data interface{}) error {
+		var payload T
+		if err := decodePayload(data, &payload); err != nil {
+			return &EventError{Code: CodeInvalidPayload, Err: err}
+		}
+		if validator, ok := any(payload).(Validator); ok {
+			if err := validator.Validate(); err != nil {
+				return &EventError{Code: CodeInvalidPayload, Err: err}
+			}
+		}
+		return fn(payload)
+	}
+}
+
+// Ignore registers an event that is received but needs no handling, such as events the gateway publishes itself.
+func Ignore(event string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[event] = func(interface{}) error { return nil }
+}
+
+// Events returns the names of the registered events.
+func Events() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	events := make([]string, 0, len(registry))
+	for event := range registry {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	return events
+}
+
+// HandleEvent handles the given request with the handler registered for its event.
+//
+// Parameters:
+// - request: the event, its data and where to reply to.
+//
+// Returns: None. If the event is unknown or its handler fails, an ErrorReply is published to request.ReplyTo.
+func HandleEvent(request Request) {
+	registryMu.RLock()
+	handle, ok := registry[request.Event]
+	registryMu.RUnlock()
+
+	var err error
+	if !ok {
+		err = &EventError{Code: CodeUnknownEvent, Err: fmt.Errorf("unknown event %q", request.Event)}
+	} else {
+		err = handle(request.Data)
+	}
+	if err == nil {
+		return
+	}
+
+	fmt.Printf("HandleEvent: %s: %v\n", request.Event, err)
+
+	// Never answer an error with another error.
+	if request.Event == ErrorEvent {
+		return
+	}
+
+	// Published asynchronously, since waiting for a publish inside the MQTT message handler can block it.
+	go PublishTo(request.ReplyTo, Message{
+		Event: ErrorEvent,
+		Data: ErrorReply{
+			Event:     request.Event,
+			RequestID: request.ID,
+			Code:      errorCode(err),
+			Message:   err.Error(),
+		},
+	})
+}
+
+// errorCode returns the code of an EventError, or derives it from well-known errors.
+func errorCode(err error) string {
+	var eventErr *EventError
+	switch {
+	case errors.As(err, &eventErr):
+		return eventErr.Code
+	case errors.Is(err, ErrUnknownSensorToken):
+		return CodeUnknownSensor
+	case errors.Is(err, ErrSamplesDropped):
+		return CodeRejected
+	}
+	return CodeInternal
+}
+
+func init() {
+	Ignore(ErrorEvent)
+	Ignore(SleepStageReadyEvent)
+	Ignore(ModelLoadedEvent)
+	Ignore(ModelStatusEvent)
+	Ignore(DeviceStatusEvent)
+}
@@ -0,0 +1,340 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/entity"
+)
+
+// SessionState is the state of a patient's sleep session.
+type SessionState int
+
+const (
+	// SessionIdle means the patient has no open session.
+	SessionIdle SessionState = iota
+	// SessionRecording means samples are arriving for the session.
+	SessionRecording
+	// SessionGap means no sample arrived within the gap timeout, but the session is still open.
+	SessionGap
+	// SessionClosed means the session timed out and has been handed over for classification.
+	SessionClosed
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case SessionIdle:
+		return "idle"
+	case SessionRecording:
+		return "recording"
+	case SessionGap:
+		return "gap"
+	case SessionClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("SessionState(%d)", int(s))
+}
+
+// Session is a single sleep recording of a patient. Its ID is the ID of the entity.SleepData row.
+type Session struct {
+	ID            uint
+	PatientID     uint
+	State         SessionState
+	StartTime     time.Time
+	LastInputTime time.Time
+
+	timer *time.Timer
+}
+
+// SessionManager tracks an independent sleep session for every patient.
+//
+// A session goes from idle to recording on the first sample of a patient, to gap when no sample
+// arrived within the gap timeout, back to recording on the next sample, and to closed when no
+// sample arrived within the inactivity timeout. Closing a session calls the close handler with it.
+type SessionManager struct {
+	mu                sync.Mutex
+	sessions          map[uint]*Session
+	gapTimeout        time.Duration
+	inactivityTimeout time.Duration
+	onClose           func(Session)
+}
+
+// Sessions is the session manager used by the event handlers.
+var Sessions *SessionManager
+
+// NewSessionManager creates a session manager with the given timeouts and close handler.
+//
+// Parameters:
+// - gapTimeout: the time without samples after which a session is marked as gap.
+// - inactivityTimeout: the time without samples after which a session is closed.
+// - onClose: the function called, in its own goroutine, with every closed session.
+//
+// Returns: the new *SessionManager.
+func NewSessionManager(gapTimeout, inactivityTimeout time.Duration, onClose func(Session)) *SessionManager {
+	if gapTimeout <= 0 || gapTimeout > inactivityTimeout {
+		gapTimeout = inactivityTimeout
+	}
+	return &SessionManager{
+		sessions:          map[uint]*Session{},
+		gapTimeout:        gapTimeout,
+		inactivityTimeout: inactivityTimeout,
+		onClose:           onClose,
+	}
+}
+
+// SetupSessions creates the session manager from the environment variables.
+//
+// SESSION_GAP_TIMEOUT and SESSION_INACTIVITY_TIMEOUT are Go durations and default to 10s and 30s.
+// Closed sessions are classified and handed over to quantification.
+func SetupSessions() {
+	gapTimeout := durationFromEnv("SESSION_GAP_TIMEOUT", 10*time.Second)
+	inactivityTimeout := durationFromEnv("SESSION_INACTIVITY_TIMEOUT", 30*time.Second)
+
+	Sessions = NewSessionManager(gapTimeout, inactivityTimeout, closeSession)
+}
+
+// Record registers samples of a patient taken between firstInputTime and lastInputTime.
+//
+// It opens a new session, creating its entity.SleepData row, if the patient is idle.
+// It returns a copy of the session the samples belong to.
+func (m *SessionManager) Record(patientID uint, firstInputTime, lastInputTime time.Time) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[patientID]
+	if !ok {
+		sleepData := entity.SleepData{
+			PatientID:      patientID,
+			FirstInputTime: firstInputTime,
+			LastInputTime:  lastInputTime,
+		}
+		if err := DB.Create(&sleepData).Error; err != nil {
+			return Session{}, err
+		}
+
+		session = &Session{
+			ID:        sleepData.ID,
+			PatientID: patientID,
+			StartTime: firstInputTime,
+		}
+		m.sessions[patientID] = session
+		session.timer = time.AfterFunc(m.gapTimeout, func() { m.expire(patientID, session.ID) })
+	} else {
+		session.timer.Reset(m.gapTimeout)
+	}
+
+	session.State = SessionRecording
+	if lastInputTime.After(session.LastInputTime) {
+		session.LastInputTime = lastInputTime
+	}
+
+	return *session, nil
+}
+
+// State returns the state of the patient's session, or SessionIdle if the patient has none.
+func (m *SessionManager) State(patientID uint) SessionState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[patientID]; ok {
+		return session.State
+	}
+	return SessionIdle
+}
+
+// Close closes the patient's open session immediately.
+//
+// It returns false if the patient has no open session.
+func (m *SessionManager) Close(patientID uint) bool {
+	m.mu.Lock()
+	session, ok := m.sessions[patientID]
+	if ok {
+		session.timer.Stop()
+		m.closeLocked(session)
+	}
+	m.mu.Unlock()
+	return ok
+}
+
+// Resume re-arms a session recovered after a restart as if it were in a gap: it continues with the next
+// sample of its patient, or is closed after the inactivity timeout. If the patient already has an open
+// session, the recovered one is closed at once.
+func (m *SessionManager) Resume(session Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[session.PatientID]; ok {
+		// The patient's open session stays in the map; only the stale one is handed over.
+		session.State = SessionClosed
+		if m.onClose != nil {
+			go m.onClose(session)
+		}
+		return
+	}
+
+	resumed := &session
+	resumed.State = SessionGap
+	m.sessions[session.PatientID] = resumed
+	resumed.timer = time.AfterFunc(m.inactivityTimeout, func() { m.expire(session.PatientID, session.ID) })
+}
+
+// RecoverSessions resumes the sessions that were open when the gateway stopped, so that they are
+// classified once their samples in the write-ahead log are replayed.
+//
+// A session is recovered when its sleep data has no sleep stages, its last sample is more recent than
+// SESSION_RECOVERY_WINDOW, 24h by default, and it is not an imported recording with reference stages.
+// The most recent session of a patient is resumed, and the older ones are closed at once.
+func RecoverSessions() {
+	since := time.Now().Add(-durationFromEnv("SESSION_RECOVERY_WINDOW", 24*time.Hour))
+
+	var open []entity.SleepData
+	err := DB.Where("first_sleep_stage_id = ? AND last_input_time > ?", 0, since).
+		Where("NOT EXISTS (?)", DB.Model(&entity.ReferenceStage{}).Select("1").Where("reference_stages.sleep_data_id = sleep_data.id")).
+		Order("last_input_time desc").
+		Find(&open).Error
+	if err != nil {
+		fmt.Println("RecoverSessions: Failed to find open sessions:", err)
+		return
+	}
+
+	for _, sleepData := range open {
+		fmt.Printf("Recovering session %d of patient %d\n", sleepData.ID, sleepData.PatientID)
+		Sessions.Resume(Session{
+			ID:            sleepData.ID,
+			PatientID:     sleepData.PatientID,
+			StartTime:     sleepData.FirstInputTime,
+			LastInputTime: sleepData.LastInputTime,
+		})
+	}
+}
+
+// expire advances the session from recording to gap, or from gap to closed, when its timer fires.
+func (m *SessionManager) expire(patientID, sessionID uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[patientID]
+	if !ok || session.ID != sessionID {
+		return
+	}
+
+	switch session.State {
+	case SessionRecording:
+		session.State = SessionGap
+		session.timer.Reset(m.inactivityTimeout - m.gapTimeout)
+	case SessionGap:
+		m.closeLocked(session)
+	}
+}
+
+// closeLocked marks the session as closed and hands it over to the close handler.
+// The caller must hold m.mu.
+func (m *SessionManager) closeLocked(session *Session) {
+	session.State = SessionClosed
+	delete(m.sessions, session.PatientID)
+
+	if m.onClose != nil {
+		go m.onClose(*session)
+	}
+}
+
+// closeSession classifies a closed session and notifies quantification that its sleep stages are ready.
+// A session that recorded no ECG is discarded instead.
+func closeSession(session Session) {
+	fmt.Printf("Session %d of patient %d closed\n", session.ID, session.PatientID)
+
+	drained := waitForIngestLog(time.Minute)
+	if drained {
+		ingestWriter.Forget(session.ID)
+	} else {
+		fmt.Printf("Session %d: write-ahead log not drained, classifying stored data only\n", session.ID)
+	}
+
+	err := classifySession(session)
+	if errors.Is(err, errNoECG) && drained {
+		// Nothing was recorded: drop the sleep data so the session is not recovered on every restart.
+		fmt.Printf("Session %d: no ECG data, discarding the session\n", session.ID)
+		if err := DB.Delete(&entity.SleepData{ID: session.ID}).Error; err != nil {
+			fmt.Printf("Failed to discard session %d: %v\n", session.ID, err)
+		}
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to classify session %d: %v\n", session.ID, err)
+		return
+	}
+
+	Publish(Message{
+		Event: SleepStageReadyEvent,
+		Data: SleepStageReady{
+			SessionID: session.ID,
+			PatientID: session.PatientID,
+		},
+	})
+}
+
+// SessionCommand is the payload of the "session-start" and "session-stop" events.
+type SessionCommand struct {
+	SensorToken string `mapstructure:"sensor_token"`
+}
+
+// Validate checks that the command names its sensor.
+func (c SessionCommand) Validate() error {
+	if c.SensorToken == "" {
+		return errors.New("missing sensor_token")
+	}
+	return nil
+}
+
+// StartSession opens a session for the patient of the sensor before its first sample arrives.
+// It does nothing if the patient already has an open session.
+func StartSession(command SessionCommand) error {
+	patientID, err := ResolvePatient(command.SensorToken)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	session, err := Sessions.Record(patientID, now, now)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Session %d of patient %d started\n", session.ID, patientID)
+	return nil
+}
+
+// StopSession closes the session of the patient of the sensor without waiting for the inactivity timeout.
+func StopSession(command SessionCommand) error {
+	patientID, err := ResolvePatient(command.SensorToken)
+	if err != nil {
+		return err
+	}
+
+	if !Sessions.Close(patientID) {
+		return &EventError{Code: CodeRejected, Err: fmt.Errorf("patient %d has no open session", patientID)}
+	}
+	return nil
+}
+
+func init() {
+	Register("session-start", StartSession)
+	Register("session-stop", StopSession)
+}
+
+// durationFromEnv reads a Go duration from the given environment variable, falling back to def.
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		fmt.Printf("Invalid %s %q, using %s\n", key, value, def)
+		return def
+	}
+	return d
+}
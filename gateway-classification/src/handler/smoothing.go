@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/stanleydv12/gateway-classification/src/classify"
+)
+
+// predictionConfidence is the probability given to the predicted stage of backends returning no probabilities.
+const predictionConfidence = 0.7
+
+// smoothedMethodSuffix is appended to the Method of sleep stages changed by the smoothing.
+const smoothedMethodSuffix = "+hmm"
+
+// stageTransitions is the hidden Markov model used to smooth hypnograms, nil to store stages as predicted.
+var stageTransitions *classify.TransitionMatrix
+
+// setupSmoothing loads the stage transition matrix used to smooth hypnograms.
+//
+// HMM_TRANSITIONS_PATH is a JSON file with the labels, transitions and initial probabilities of the
+// stages, such as one learned from labeled nights. Without it, HMM_SMOOTHING=true uses a matrix where
+// a stage lasts another epoch with probability HMM_STAY_PROBABILITY, 0.9 by default, over the given labels.
+func setupSmoothing(labels []string) {
+	if path := os.Getenv("HMM_TRANSITIONS_PATH"); path != "" {
+		tm, err := classify.LoadTransitionMatrix(path)
+		if err != nil {
+			log.Fatalf("Error loading stage transition matrix: %v", err)
+		}
+		stageTransitions = tm
+		fmt.Println("Smoothing hypnograms with the transition matrix", path)
+		return
+	}
+
+	if strings.EqualFold(os.Getenv("HMM_SMOOTHING"), "true") {
+		stay := floatFromEnv("HMM_STAY_PROBABILITY", classify.DefaultStayProbability)
+		stageTransitions = classify.NewStickyTransitions(labels, stay)
+		fmt.Printf("Smoothing hypnograms with stay probability %g\n", stay)
+	}
+}
+
+// smoothStages replaces the predicted stages of a session's epochs by the most likely hypnogram.
+//
+// The probabilities of a prediction are in the order of its Labels, those of the model or service that
+// made it. Predictions without probabilities get predictionConfidence for their stage, and unscorable
+// epochs stay unscorable.
+func smoothStages(predictions []PredictionResponse) error {
+	index := make(map[string]int, len(stageTransitions.Labels))
+	for i, label := range stageTransitions.Labels {
+		index[label] = i
+	}
+	n := len(stageTransitions.Labels)
+
+	emissions := make([][]float64, len(predictions))
+	for t, prediction := range predictions {
+		if prediction.Prediction == UnscorableStage {
+			continue
+		}
+
+		labels := prediction.Labels
+		if len(prediction.Probabilities) > 0 && len(prediction.Probabilities) == len(labels) {
+			emission := make([]float64, n)
+			for i, p := range prediction.Probabilities {
+				if j, ok := index[labels[i]]; ok {
+					emission[j] = p
+				}
+			}
+			emissions[t] = emission
+			continue
+		}
+
+		if j, ok := index[prediction.Prediction]; ok {
+			emission := make([]float64, n)
+			for i := range emission {
+				if n > 1 {
+					emission[i] = (1 - predictionConfidence) / float64(n-1)
+				}
+			}
+			emission[j] = predictionConfidence
+			emissions[t] = emission
+		}
+	}
+
+	path, err := stageTransitions.Viterbi(emissions)
+	if err != nil {
+		return err
+	}
+
+	for t := range predictions {
+		if emissions[t] == nil || predictions[t].Prediction == path[t] {
+			continue
+		}
+		predictions[t].Prediction = path[t]
+		predictions[t].Method += smoothedMethodSuffix
+	}
+	return nil
+}
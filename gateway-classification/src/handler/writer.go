@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/entity"
+	"gorm.io/gorm"
+)
+
+// insertBatchSize is the number of ECG rows sent to the database per INSERT statement.
+const insertBatchSize = 500
+
+// ECGWriter buffers ECG samples per session and writes them to the database with batched inserts.
+//
+// It keeps the bookkeeping of every session in memory: the ID of its first ECG, which the following
+// samples reference, and the last input time already stored in its sleep data row. A flush therefore
+// only queries the database for a session it has not seen yet.
+type ECGWriter struct {
+	flushSize     int
+	flushInterval time.Duration
+
+	mu             sync.Mutex
+	sessionIDs     []uint
+	buffers        map[uint][]entity.ECG
+	buffered       int
+	firstECGIDs    map[uint]uint
+	lastInputTimes map[uint]time.Time
+}
+
+// NewECGWriter creates a writer flushed every flushSize samples or every flushInterval.
+func NewECGWriter(flushSize int, flushInterval time.Duration) *ECGWriter {
+	return &ECGWriter{
+		flushSize:      flushSize,
+		flushInterval:  flushInterval,
+		buffers:        map[uint][]entity.ECG{},
+		firstECGIDs:    map[uint]uint{},
+		lastInputTimes: map[uint]time.Time{},
+	}
+}
+
+// Add buffers a sample in the buffer of its session.
+func (w *ECGWriter) Add(sample entity.ECG) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.buffers[sample.SleepDataID]; !ok {
+		w.sessionIDs = append(w.sessionIDs, sample.SleepDataID)
+	}
+	w.buffers[sample.SleepDataID] = append(w.buffers[sample.SleepDataID], sample)
+	w.buffered++
+}
+
+// Len returns the number of buffered samples.
+func (w *ECGWriter) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buffered
+}
+
+// Full reports whether the writer holds enough samples to be flushed.
+func (w *ECGWriter) Full() bool {
+	return w.Len() >= w.flushSize
+}
+
+// FlushInterval returns the maximum time a sample stays buffered.
+func (w *ECGWriter) FlushInterval() time.Duration {
+	return w.flushInterval
+}
+
+// Flush writes the buffered samples to the database in a single transaction.
+//
+// The first ECG of a session has a ReferenceID of 0 and is stored as the session's FirstECGID;
+// the following ones reference it. The LastInputTime of a session is updated once per flush, and
+// only when it moves forward. If the transaction fails, the samples stay buffered.
+func (w *ECGWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buffered == 0 {
+		return nil
+	}
+
+	newFirstECGIDs := map[uint]uint{}
+	newLastInputTimes := map[uint]time.Time{}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, sessionID := range w.sessionIDs {
+			samples := append([]entity.ECG(nil), w.buffers[sessionID]...)
+			sleepData := entity.SleepData{ID: sessionID}
+
+			firstECGID, ok := w.firstECGIDs[sessionID]
+			if !ok {
+				err := tx.Select("first_ecg_id", "last_input_time").First(&sleepData).Error
+				if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					return err
+				}
+				firstECGID = sleepData.FirstECGID
+				w.lastInputTimes[sessionID] = sleepData.LastInputTime
+			}
+
+			if firstECGID == 0 {
+				first := samples[0]
+				first.ID = 0
+				first.ReferenceID = 0
+				if err := tx.Create(&first).Error; err != nil {
+					return err
+				}
+				firstECGID = first.ID
+
+				err := tx.Model(&sleepData).Updates(entity.SleepData{
+					FirstECGID:     first.ID,
+					FirstInputTime: first.InputTime,
+				}).Error
+				if err != nil {
+					return err
+				}
+				samples = samples[1:]
+			}
+			newFirstECGIDs[sessionID] = firstECGID
+
+			lastInputTime := w.lastInputTimes[sessionID]
+			for i := range samples {
+				samples[i].ID = 0
+				samples[i].ReferenceID = firstECGID
+				if samples[i].InputTime.After(lastInputTime) {
+					lastInputTime = samples[i].InputTime
+				}
+			}
+
+			if len(samples) > 0 {
+				if err := tx.CreateInBatches(samples, insertBatchSize).Error; err != nil {
+					return err
+				}
+			}
+
+			if lastInputTime.After(w.lastInputTimes[sessionID]) {
+				if err := tx.Model(&sleepData).Update("last_input_time", lastInputTime).Error; err != nil {
+					return err
+				}
+				newLastInputTimes[sessionID] = lastInputTime
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for sessionID, firstECGID := range newFirstECGIDs {
+		w.firstECGIDs[sessionID] = firstECGID
+	}
+	for sessionID, lastInputTime := range newLastInputTimes {
+		w.lastInputTimes[sessionID] = lastInputTime
+	}
+
+	w.sessionIDs = w.sessionIDs[:0]
+	w.buffers = map[uint][]entity.ECG{}
+	w.buffered = 0
+
+	return nil
+}
+
+// Forget drops the bookkeeping of a closed session once its samples have been flushed.
+func (w *ECGWriter) Forget(sessionID uint) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, buffered := w.buffers[sessionID]; buffered {
+		return
+	}
+	delete(w.firstECGIDs, sessionID)
+	delete(w.lastInputTimes, sessionID)
+}
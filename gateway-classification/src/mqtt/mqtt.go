@@ -12,8 +12,10 @@ import (
 )
 
 type Message struct {
-	Event string      `json:"event"`
-	Data  interface{} `json:"data"`
+	Event   string      `json:"event"`
+	ID      string      `json:"id,omitempty"`
+	ReplyTo string      `json:"reply_to,omitempty"`
+	Data    interface{} `json:"data"`
 }
 
 var Client mqtt.Client
@@ -28,7 +30,17 @@ var messagePubHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Me
 		return
 	}
 
-	handler.HandleEvent(receivedMessage.Event, receivedMessage.Data)
+	replyTo := receivedMessage.ReplyTo
+	if replyTo == "" {
+		replyTo = replyTopic()
+	}
+
+	handler.HandleEvent(handler.Request{
+		Event:   receivedMessage.Event,
+		ID:      receivedMessage.ID,
+		ReplyTo: replyTo,
+		Data:    receivedMessage.Data,
+	})
 }
 
 var connectHandler mqtt.OnConnectHandler = func(client mqtt.Client) {
@@ -90,7 +102,26 @@ func Sub(client mqtt.Client) {
 // It takes a single parameter, msg, which is of type interface{}.
 // The function does not return any value.
 func Pub(msg interface{}) {
-	topic := os.Getenv("MQTT_TOPIC")
+	PubTo("", msg)
+}
+
+// PubTo publishes the given message to the given MQTT topic.
+//
+// An empty topic publishes to the MQTT topic the gateway subscribes to.
+func PubTo(topic string, msg interface{}) {
+	if topic == "" {
+		topic = os.Getenv("MQTT_TOPIC")
+	}
 	token := Client.Publish(topic, 1, false, msg)
 	token.Wait()
 }
+
+// replyTopic returns the topic error replies are published to when a message has no reply_to.
+//
+// It is MQTT_REPLY_TOPIC, or the subscribed topic followed by "/reply".
+func replyTopic() string {
+	if topic := os.Getenv("MQTT_REPLY_TOPIC"); topic != "" {
+		return topic
+	}
+	return os.Getenv("MQTT_TOPIC") + "/reply"
+}
@@ -0,0 +1,122 @@
+package recording
+
+import (
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// UnscoredStage is the reference stage of epochs the scorer did not stage, such as movement time.
+const UnscoredStage = "UNSCORED"
+
+// Annotation is an event annotated on a recording, such as a scored sleep stage.
+type Annotation struct {
+	// Onset is the time of the event in seconds from the start of the recording.
+	Onset float64
+	// Duration is the duration of the event in seconds, 0 when the file does not give it.
+	Duration float64
+	// Code is the WFDB annotation code, 0 for EDF+ annotations.
+	Code int
+	// Text is the auxiliary text of a WFDB annotation or the text of an EDF+ annotation.
+	Text string
+}
+
+// ScoredEpoch is a sleep stage scored on an epoch of a recording.
+type ScoredEpoch struct {
+	// Start and End are in seconds from the start of the recording.
+	Start, End float64
+	// Stage is AWAKE, N1, N2, N3, REM or UNSCORED.
+	Stage string
+}
+
+// ReadAnnotations reads the annotations at path: the annotations of an EDF+ file, such as a Sleep-EDF
+// hypnogram, or a WFDB annotation file, such as a .st file, of a record sampled at fs Hz.
+func ReadAnnotations(path string, fs float64) ([]Annotation, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".edf", ".rec":
+		return ReadEDFAnnotations(path)
+	}
+	return ReadWFDBAnnotations(path, fs)
+}
+
+// ParseSleepStage returns the stage, in the labels of the gateway, of a sleep stage annotation, and
+// false for annotations that are not sleep stages.
+//
+// It reads the stages of Rechtschaffen & Kales and AASM scoring as written by PhysioNet databases:
+// W, 1, 2, 3, 4 and R in the aux text of WFDB annotations, which may be followed by respiratory events,
+// and "Sleep stage W" to "Sleep stage R" in EDF+. Stages 3 and 4 are both N3. Movement time and
+// unknown stages are UNSCORED.
+func ParseSleepStage(text string) (string, bool) {
+	stage := strings.ToUpper(strings.TrimSpace(text))
+	switch {
+	case stage == "MOVEMENT TIME":
+		return UnscoredStage, true
+	case strings.HasPrefix(stage, "SLEEP STAGE "):
+		stage = strings.TrimSpace(strings.TrimPrefix(stage, "SLEEP STAGE "))
+	case stage != "":
+		stage = strings.Fields(stage)[0]
+	}
+
+	switch stage {
+	case "W", "WAKE", "AWAKE":
+		return "AWAKE", true
+	case "1", "S1", "N1":
+		return "N1", true
+	case "2", "S2", "N2":
+		return "N2", true
+	case "3", "4", "S3", "S4", "N3", "N4":
+		return "N3", true
+	case "R", "REM":
+		return "REM", true
+	case "MT", "M", "?", "UNSCORED":
+		return UnscoredStage, true
+	}
+	return "", false
+}
+
+// SleepStages returns the scored stages of the annotations, split in epochs of epochLength seconds.
+//
+// A stage annotation without a duration, as in WFDB, lasts until the next one, and the last one
+// lasts one epoch. Epochs are aligned on the first stage annotation; time not covered by a stage,
+// such as the gap between two EDF+ stages, is left out.
+func SleepStages(annotations []Annotation, epochLength float64) []ScoredEpoch {
+	type stage struct {
+		onset, duration float64
+		label           string
+	}
+
+	var stages []stage
+	for _, annotation := range annotations {
+		if label, ok := ParseSleepStage(annotation.Text); ok {
+			stages = append(stages, stage{annotation.Onset, annotation.Duration, label})
+		}
+	}
+	if len(stages) == 0 || epochLength <= 0 {
+		return nil
+	}
+	sort.SliceStable(stages, func(i, j int) bool { return stages[i].onset < stages[j].onset })
+
+	var epochs []ScoredEpoch
+	origin := stages[0].onset
+	for i, s := range stages {
+		end := s.onset + s.duration
+		if s.duration <= 0 {
+			end = s.onset + epochLength
+			if i+1 < len(stages) {
+				end = stages[i+1].onset
+			}
+		}
+
+		// Epoch boundaries within the stage, on the grid starting at the first stage.
+		first := math.Round((s.onset - origin) / epochLength)
+		for k := first; ; k++ {
+			start := origin + k*epochLength
+			if start+epochLength/2 > end {
+				break
+			}
+			epochs = append(epochs, ScoredEpoch{Start: start, End: start + epochLength, Stage: s.label})
+		}
+	}
+	return epochs
+}
@@ -0,0 +1,87 @@
+package recording
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReadCSV reads an ECG recording from CSV, one sample per row.
+//
+// Rows before the first one whose ECG column is a number are headers, as written by PhysioNet's
+// rdsamp, and are skipped. The sampling rate is options.SamplingRate, or is estimated from the
+// median interval of the time column when it is not set.
+func ReadCSV(r io.Reader, options Options) (*Recording, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+
+	var signal, times []float64
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line++
+
+		if options.Column >= len(record) || options.TimeColumn >= len(record) {
+			return nil, fmt.Errorf("line %d has %d columns", line, len(record))
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[options.Column]), 64)
+		if err != nil {
+			if len(signal) == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d, column %d: %w", line, options.Column+1, err)
+		}
+		signal = append(signal, value)
+
+		if options.TimeColumn >= 0 {
+			t, err := strconv.ParseFloat(strings.TrimSpace(record[options.TimeColumn]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d, column %d: %w", line, options.TimeColumn+1, err)
+			}
+			times = append(times, t)
+		}
+	}
+	if len(signal) == 0 {
+		return nil, errors.New("no samples")
+	}
+
+	fs := options.SamplingRate
+	if fs <= 0 {
+		fs = samplingRateFromTimes(times)
+		if fs <= 0 {
+			return nil, errors.New("the sampling rate is needed for CSV files without a time column")
+		}
+	}
+
+	return &Recording{Signal: signal, SamplingRate: fs}, nil
+}
+
+// samplingRateFromTimes estimates the sampling rate from the median interval between sample times in seconds.
+func samplingRateFromTimes(times []float64) float64 {
+	var intervals []float64
+	for i := 1; i < len(times); i++ {
+		if interval := times[i] - times[i-1]; interval > 0 {
+			intervals = append(intervals, interval)
+		}
+	}
+	if len(intervals) == 0 {
+		return 0
+	}
+
+	// Rounded to a millionth of a Hz, since the times are usually written with a few decimals.
+	sort.Float64s(intervals)
+	return math.Round(1e6/intervals[len(intervals)/2]) / 1e6
+}
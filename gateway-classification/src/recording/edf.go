@@ -0,0 +1,291 @@
+package recording
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EDF and EDF+ files, the format of most polysomnography systems and of PhysioNet's Sleep-EDF.
+//
+// A 256-byte header describes the file and is followed by 256 bytes per signal, then by data records
+// of a fixed duration holding a fixed number of 16-bit little-endian samples of every signal in turn.
+// EDF+ stores its annotations as time-stamped annotation lists in "EDF Annotations" signals.
+
+// edfAnnotationsLabel is the label of EDF+ annotation signals.
+const edfAnnotationsLabel = "EDF Annotations"
+
+// edfSignal is a signal of an EDF header.
+type edfSignal struct {
+	Label                    string
+	Units                    string
+	PhysicalMin, PhysicalMax float64
+	DigitalMin, DigitalMax   float64
+	SamplesPerRecord         int
+	// offset is the byte offset of the signal within a data record.
+	offset int
+}
+
+// edfFile is a parsed EDF file.
+type edfFile struct {
+	Start          time.Time
+	Continuous     bool
+	Records        int
+	RecordDuration float64
+	Signals        []edfSignal
+	recordSize     int
+	data           []byte
+}
+
+// ReadEDF reads an ECG signal and the annotations of an EDF or EDF+ file.
+//
+// signal is the label or the index of the signal to read. Empty reads the first signal whose label
+// contains ECG or EKG. The recording is nil when the file has no such signal, such as a Sleep-EDF
+// hypnogram holding only annotations. Discontinuous EDF+ files are only read for their annotations.
+func ReadEDF(path, signal string) (*Recording, []Annotation, error) {
+	file, err := readEDF(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	annotations, err := file.annotations()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	index, err := file.signalIndex(signal)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if index < 0 {
+		return nil, annotations, nil
+	}
+	if !file.Continuous {
+		return nil, nil, fmt.Errorf("%s: signals of discontinuous EDF+ files are not supported", path)
+	}
+	if file.RecordDuration <= 0 || file.Signals[index].Label == edfAnnotationsLabel {
+		return nil, nil, fmt.Errorf("%s: signal %q has no samples", path, file.Signals[index].Label)
+	}
+
+	return &Recording{
+		Name:         strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Signal:       file.physical(index),
+		SamplingRate: float64(file.Signals[index].SamplesPerRecord) / file.RecordDuration,
+		Start:        file.Start,
+	}, annotations, nil
+}
+
+// ReadEDFAnnotations reads the annotations of an EDF+ file, such as a Sleep-EDF hypnogram.
+func ReadEDFAnnotations(path string) ([]Annotation, error) {
+	file, err := readEDF(path)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations, err := file.annotations()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return annotations, nil
+}
+
+// readEDF parses the header of an EDF file and keeps its data records.
+func readEDF(path string) (*edfFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 256 {
+		return nil, fmt.Errorf("%s: truncated EDF header", path)
+	}
+
+	field := func(offset, size int) string {
+		return strings.TrimSpace(string(data[offset : offset+size]))
+	}
+
+	headerSize, err := strconv.Atoi(field(184, 8))
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid header size %q", path, field(184, 8))
+	}
+	nsig, err := strconv.Atoi(field(252, 4))
+	if err != nil || nsig <= 0 || headerSize != 256*(nsig+1) || len(data) < headerSize {
+		return nil, fmt.Errorf("%s: invalid signal count %q", path, field(252, 4))
+	}
+
+	file := &edfFile{
+		Start:      parseEDFStart(field(168, 8), field(176, 8)),
+		Continuous: !strings.HasPrefix(field(192, 44), "EDF+D"),
+	}
+	file.Records, err = strconv.Atoi(field(236, 8))
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid record count %q", path, field(236, 8))
+	}
+	file.RecordDuration, err = strconv.ParseFloat(field(244, 8), 64)
+	if err != nil || file.RecordDuration < 0 {
+		return nil, fmt.Errorf("%s: invalid record duration %q", path, field(244, 8))
+	}
+
+	// Every signal field is stored for all signals before the next field.
+	file.Signals = make([]edfSignal, nsig)
+	signalField := func(offset, size, i int) string {
+		return field(256+offset*nsig+size*i, size)
+	}
+	number := func(offset, size, i int, name string) (float64, error) {
+		v, err := strconv.ParseFloat(signalField(offset, size, i), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: signal %d: invalid %s %q", path, i+1, name, signalField(offset, size, i))
+		}
+		return v, nil
+	}
+	for i := range file.Signals {
+		s := &file.Signals[i]
+		s.Label = signalField(0, 16, i)
+		s.Units = signalField(96, 8, i)
+		if s.PhysicalMin, err = number(104, 8, i, "physical minimum"); err != nil {
+			return nil, err
+		}
+		if s.PhysicalMax, err = number(112, 8, i, "physical maximum"); err != nil {
+			return nil, err
+		}
+		if s.DigitalMin, err = number(120, 8, i, "digital minimum"); err != nil {
+			return nil, err
+		}
+		if s.DigitalMax, err = number(128, 8, i, "digital maximum"); err != nil {
+			return nil, err
+		}
+		samples, err := number(216, 8, i, "sample count")
+		if err != nil {
+			return nil, err
+		}
+		s.SamplesPerRecord = int(samples)
+		s.offset = file.recordSize
+		file.recordSize += 2 * s.SamplesPerRecord
+	}
+
+	file.data = data[headerSize:]
+	if file.recordSize == 0 {
+		return nil, fmt.Errorf("%s: empty data records", path)
+	}
+	// The record count is -1 while a recording is in progress.
+	if available := len(file.data) / file.recordSize; file.Records < 0 || file.Records > available {
+		file.Records = available
+	}
+	return file, nil
+}
+
+// parseEDFStart parses the start date, dd.mm.yy, and time, hh.mm.ss, of an EDF header.
+// Years 85 to 99 are in the 20th century, as the EDF specification requires.
+func parseEDFStart(date, clock string) time.Time {
+	start, err := time.Parse("02.01.06 15.04.05", date+" "+clock)
+	if err != nil {
+		return time.Time{}
+	}
+	if start.Year() >= 2085 {
+		start = start.AddDate(-100, 0, 0)
+	}
+	return start
+}
+
+// signalIndex returns the index of the signal with the given label or index, of the first ECG signal
+// when signal is empty, or -1 when there is no ECG signal.
+func (f *edfFile) signalIndex(signal string) (int, error) {
+	if signal == "" {
+		for i, s := range f.Signals {
+			label := strings.ToUpper(s.Label)
+			if strings.Contains(label, "ECG") || strings.Contains(label, "EKG") {
+				return i, nil
+			}
+		}
+		return -1, nil
+	}
+
+	if i, err := strconv.Atoi(signal); err == nil {
+		if i < 0 || i >= len(f.Signals) {
+			return 0, fmt.Errorf("%d signals, no signal %d", len(f.Signals), i)
+		}
+		return i, nil
+	}
+	for i, s := range f.Signals {
+		if strings.EqualFold(s.Label, signal) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no signal %q", signal)
+}
+
+// physical returns the samples of a signal converted from digital to physical units.
+func (f *edfFile) physical(index int) []float64 {
+	s := f.Signals[index]
+	gain := 1.0
+	if s.DigitalMax != s.DigitalMin {
+		gain = (s.PhysicalMax - s.PhysicalMin) / (s.DigitalMax - s.DigitalMin)
+	}
+
+	values := make([]float64, 0, f.Records*s.SamplesPerRecord)
+	for r := 0; r < f.Records; r++ {
+		record := f.data[r*f.recordSize+s.offset:]
+		for k := 0; k < s.SamplesPerRecord; k++ {
+			digital := float64(int16(binary.LittleEndian.Uint16(record[2*k:])))
+			values = append(values, s.PhysicalMin+(digital-s.DigitalMin)*gain)
+		}
+	}
+	return values
+}
+
+// annotations parses the time-stamped annotation lists of the EDF+ annotation signals.
+//
+// A list is "+onset\x15duration\x14text\x14text\x14\x00", the duration being optional. The first list of
+// every data record only keeps time and has no text.
+func (f *edfFile) annotations() ([]Annotation, error) {
+	var annotations []Annotation
+	for i, s := range f.Signals {
+		if s.Label != edfAnnotationsLabel {
+			continue
+		}
+
+		for r := 0; r < f.Records; r++ {
+			start := r*f.recordSize + f.Signals[i].offset
+			block := f.data[start : start+2*s.SamplesPerRecord]
+			for _, list := range strings.Split(string(block), "\x00") {
+				if list == "" {
+					continue
+				}
+				parsed, err := parseAnnotationList(list)
+				if err != nil {
+					return nil, err
+				}
+				annotations = append(annotations, parsed...)
+			}
+		}
+	}
+	return annotations, nil
+}
+
+func parseAnnotationList(list string) ([]Annotation, error) {
+	parts := strings.Split(list, "\x14")
+	timing := strings.SplitN(parts[0], "\x15", 2)
+
+	onset, err := strconv.ParseFloat(timing[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid annotation onset %q", timing[0])
+	}
+	duration := 0.0
+	if len(timing) == 2 && timing[1] != "" {
+		if duration, err = strconv.ParseFloat(timing[1], 64); err != nil {
+			return nil, fmt.Errorf("invalid annotation duration %q", timing[1])
+		}
+	}
+
+	var annotations []Annotation
+	for _, text := range parts[1:] {
+		if text == "" {
+			continue
+		}
+		annotations = append(annotations, Annotation{Onset: onset, Duration: duration, Text: text})
+	}
+	return annotations, nil
+}
@@ -0,0 +1,105 @@
+package recording
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stanleydv12/gateway-classification/src/entity"
+)
+
+// ErrUnknownFormat is returned by Read for files that are neither CSV, EDF nor a WFDB record.
+var ErrUnknownFormat = errors.New("recording: unknown file format")
+
+// ErrNoECG is returned by Read for EDF files without an ECG signal.
+var ErrNoECG = errors.New("recording: no ECG signal, select one by label or index")
+
+// Recording is an ECG signal recorded at a constant sampling rate, such as a PhysioNet night.
+type Recording struct {
+	// Name identifies the recording, e.g. the WFDB record name or the CSV file name without extension.
+	Name string
+	// Signal holds the samples in physical units, usually mV.
+	Signal []float64
+	// SamplingRate is the sampling rate in Hz.
+	SamplingRate float64
+	// Start is the time of the first sample, zero when the file does not record it.
+	Start time.Time
+}
+
+// Duration returns the duration of the recording.
+func (r *Recording) Duration() time.Duration {
+	if r.SamplingRate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(len(r.Signal)) / r.SamplingRate * float64(time.Second))
+}
+
+// SampleTime returns the time of sample i, counted from Start.
+func (r *Recording) SampleTime(i int) time.Time {
+	return r.Start.Add(time.Duration(float64(i) / r.SamplingRate * float64(time.Second)))
+}
+
+// ECG returns the samples from index from to index to, excluded, as ECG rows of the patient's sleep data.
+func (r *Recording) ECG(patientID, sleepDataID uint, from, to int) []entity.ECG {
+	samples := make([]entity.ECG, 0, to-from)
+	for i := from; i < to; i++ {
+		samples = append(samples, entity.ECG{
+			PatientID:   patientID,
+			SleepDataID: sleepDataID,
+			Value:       r.Signal[i],
+			InputTime:   r.SampleTime(i),
+		})
+	}
+	return samples
+}
+
+// Options selects the signal read from a file.
+type Options struct {
+	// SamplingRate is the sampling rate of a CSV file without a time column. WFDB records carry their own.
+	SamplingRate float64
+	// Column is the CSV column holding the ECG, from 0.
+	Column int
+	// TimeColumn is the CSV column holding the time of every sample in seconds, -1 for none.
+	TimeColumn int
+	// Signal is the WFDB or EDF signal to read, by index, description or label. Empty reads the first ECG signal.
+	Signal string
+}
+
+// Read reads the recording at path: a WFDB record, given by its .hea or .dat file or by the record
+// path without extension, an EDF or EDF+ file, or a CSV file.
+func Read(path string, options Options) (*Recording, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".hea", ".dat":
+		return ReadWFDB(strings.TrimSuffix(path, filepath.Ext(path))+".hea", options.Signal)
+	case ".edf", ".rec":
+		recording, _, err := ReadEDF(path, options.Signal)
+		if err != nil {
+			return nil, err
+		}
+		if recording == nil {
+			return nil, ErrNoECG
+		}
+		return recording, nil
+	case ".csv", ".txt":
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		recording, err := ReadCSV(file, options)
+		if err != nil {
+			return nil, err
+		}
+		recording.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		return recording, nil
+	case "":
+		if _, err := os.Stat(path + ".hea"); err == nil {
+			return ReadWFDB(path+".hea", options.Signal)
+		}
+	}
+	return nil, ErrUnknownFormat
+}
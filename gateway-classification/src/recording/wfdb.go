@@ -0,0 +1,338 @@
+package recording
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WFDB records, the format of PhysioNet databases.
+//
+// A record is a .hea header describing its signals and one or more .dat files holding their samples.
+// Signals stored in the same file are interleaved frame by frame. Only single-segment records with
+// one sample per signal and frame are read, in formats 16, 61, 80 and 212.
+
+// defaultGain is the gain, in ADC units per physical unit, of signals whose header does not set it.
+const defaultGain = 200
+
+// wfdbSignal is a signal of a WFDB header.
+type wfdbSignal struct {
+	File        string
+	Format      int
+	Offset      int64
+	Gain        float64
+	Baseline    int
+	Units       string
+	Description string
+}
+
+// wfdbHeader is a parsed WFDB header.
+type wfdbHeader struct {
+	Name         string
+	SamplingRate float64
+	Samples      int
+	Start        time.Time
+	Signals      []wfdbSignal
+}
+
+// ReadWFDB reads an ECG signal of the WFDB record whose header is at headerPath.
+//
+// signal is the index or the description of the signal to read. Empty reads the first signal whose
+// description contains ECG, or the first signal. Samples are converted to physical units with the gain
+// and baseline of the signal; invalid samples repeat the previous valid one.
+func ReadWFDB(headerPath, signal string) (*Recording, error) {
+	header, err := readWFDBHeader(headerPath)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := header.signalIndex(signal)
+	if err != nil {
+		return nil, err
+	}
+	selected := header.Signals[index]
+
+	// Signals of the same file are interleaved in header order.
+	var group []int
+	for i, s := range header.Signals {
+		if s.File == selected.File {
+			group = append(group, i)
+		}
+	}
+	position := 0
+	for k, i := range group {
+		if i == index {
+			position = k
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(headerPath), selected.File))
+	if err != nil {
+		return nil, err
+	}
+	if selected.Offset > int64(len(data)) {
+		return nil, fmt.Errorf("%s is shorter than its offset %d", selected.File, selected.Offset)
+	}
+
+	samples, invalid, err := decodeWFDB(data[selected.Offset:], selected.Format)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", selected.File, err)
+	}
+
+	frames := len(samples) / len(group)
+	if header.Samples > 0 && header.Samples < frames {
+		frames = header.Samples
+	}
+
+	values := make([]float64, frames)
+	last := 0.0
+	for t := range values {
+		adc := samples[t*len(group)+position]
+		if adc != invalid {
+			last = float64(adc-selected.Baseline) / selected.Gain
+		}
+		values[t] = last
+	}
+
+	return &Recording{
+		Name:         header.Name,
+		Signal:       values,
+		SamplingRate: header.SamplingRate,
+		Start:        header.Start,
+	}, nil
+}
+
+// readWFDBHeader parses the record line and the signal lines of a WFDB header.
+func readWFDBHeader(path string) (*wfdbHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var header *wfdbHeader
+	nsig := 0
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+
+		if header == nil {
+			header, nsig, err = parseRecordLine(fields)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			continue
+		}
+		if len(header.Signals) == nsig {
+			break
+		}
+
+		signal, err := parseSignalLine(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		header.Signals = append(header.Signals, signal)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if header == nil {
+		return nil, fmt.Errorf("%s: no record line", path)
+	}
+	if len(header.Signals) != nsig {
+		return nil, fmt.Errorf("%s: %d signal lines, expected %d", path, len(header.Signals), nsig)
+	}
+	return header, nil
+}
+
+// parseRecordLine parses "name nsig fs nsamp basetime basedate", where everything after nsig is optional.
+func parseRecordLine(fields []string) (*wfdbHeader, int, error) {
+	if len(fields) < 2 {
+		return nil, 0, errors.New("record line needs a name and a signal count")
+	}
+	if strings.Contains(fields[0], "/") {
+		return nil, 0, errors.New("multi-segment records are not supported")
+	}
+
+	nsig, err := strconv.Atoi(fields[1])
+	if err != nil || nsig <= 0 {
+		return nil, 0, fmt.Errorf("invalid signal count %q", fields[1])
+	}
+
+	header := &wfdbHeader{Name: fields[0], SamplingRate: 250}
+	if len(fields) > 2 {
+		// The sampling frequency may be followed by /counter frequency and (base counter value).
+		fs := strings.FieldsFunc(fields[2], func(r rune) bool { return r == '/' || r == '(' })[0]
+		header.SamplingRate, err = strconv.ParseFloat(fs, 64)
+		if err != nil || header.SamplingRate <= 0 {
+			return nil, 0, fmt.Errorf("invalid sampling frequency %q", fields[2])
+		}
+	}
+	if len(fields) > 3 {
+		header.Samples, err = strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid sample count %q", fields[3])
+		}
+	}
+	if len(fields) > 4 {
+		header.Start = parseBaseTime(fields[4], fields[5:])
+	}
+	return header, nsig, nil
+}
+
+// parseBaseTime parses the base time, hh:mm:ss, and the base date, dd/mm/yyyy, of a record.
+// It returns the zero time when the record has no base date, as a time of day alone does not date
+// the recording, and when they can not be parsed.
+func parseBaseTime(clock string, date []string) time.Time {
+	if len(date) == 0 {
+		return time.Time{}
+	}
+	start, err := time.Parse("02/01/2006 15:04:05", date[0]+" "+clock)
+	if err != nil {
+		return time.Time{}
+	}
+	return start
+}
+
+// parseSignalLine parses "file format+offset gain(baseline)/units adcres adczero initval checksum blocksize description".
+func parseSignalLine(fields []string) (wfdbSignal, error) {
+	if len(fields) < 2 {
+		return wfdbSignal{}, errors.New("signal line needs a file name and a format")
+	}
+
+	signal := wfdbSignal{File: fields[0], Gain: defaultGain}
+	format := fields[1]
+	if i := strings.Index(format, "+"); i >= 0 {
+		offset, err := strconv.ParseInt(format[i+1:], 10, 64)
+		if err != nil {
+			return wfdbSignal{}, fmt.Errorf("invalid byte offset %q", format)
+		}
+		signal.Offset = offset
+		format = format[:i]
+	}
+	if i := strings.Index(format, ":"); i >= 0 {
+		format = format[:i]
+	}
+	if i := strings.Index(format, "x"); i >= 0 {
+		if format[i+1:] != "1" {
+			return wfdbSignal{}, fmt.Errorf("format %s: several samples per frame are not supported", fields[1])
+		}
+		format = format[:i]
+	}
+	var err error
+	if signal.Format, err = strconv.Atoi(format); err != nil {
+		return wfdbSignal{}, fmt.Errorf("invalid format %q", fields[1])
+	}
+
+	baselineSet := false
+	if len(fields) > 2 {
+		gain := fields[2]
+		if i := strings.Index(gain, "/"); i >= 0 {
+			signal.Units = gain[i+1:]
+			gain = gain[:i]
+		}
+		if i := strings.Index(gain, "("); i >= 0 {
+			baseline, err := strconv.Atoi(strings.TrimSuffix(gain[i+1:], ")"))
+			if err != nil {
+				return wfdbSignal{}, fmt.Errorf("invalid baseline %q", fields[2])
+			}
+			signal.Baseline = baseline
+			baselineSet = true
+			gain = gain[:i]
+		}
+		g, err := strconv.ParseFloat(gain, 64)
+		if err != nil {
+			return wfdbSignal{}, fmt.Errorf("invalid gain %q", fields[2])
+		}
+		if g != 0 {
+			signal.Gain = g
+		}
+	}
+	if len(fields) > 4 && !baselineSet {
+		// The baseline defaults to the ADC zero.
+		if zero, err := strconv.Atoi(fields[4]); err == nil {
+			signal.Baseline = zero
+		}
+	}
+	if len(fields) > 8 {
+		signal.Description = strings.Join(fields[8:], " ")
+	}
+	return signal, nil
+}
+
+// signalIndex returns the index of the signal with the given index or description, or of the first ECG signal.
+func (h *wfdbHeader) signalIndex(signal string) (int, error) {
+	if signal == "" {
+		for i, s := range h.Signals {
+			if strings.Contains(strings.ToUpper(s.Description), "ECG") {
+				return i, nil
+			}
+		}
+		return 0, nil
+	}
+
+	if i, err := strconv.Atoi(signal); err == nil {
+		if i < 0 || i >= len(h.Signals) {
+			return 0, fmt.Errorf("record %s has %d signals, no signal %d", h.Name, len(h.Signals), i)
+		}
+		return i, nil
+	}
+	for i, s := range h.Signals {
+		if strings.EqualFold(s.Description, signal) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("record %s has no signal %q", h.Name, signal)
+}
+
+// decodeWFDB decodes the samples of a signal file in the given format, and returns the value marking invalid samples.
+func decodeWFDB(data []byte, format int) ([]int, int, error) {
+	switch format {
+	case 16, 61:
+		samples := make([]int, len(data)/2)
+		for i := range samples {
+			lo, hi := data[2*i], data[2*i+1]
+			if format == 61 {
+				lo, hi = hi, lo
+			}
+			samples[i] = int(int16(uint16(lo) | uint16(hi)<<8))
+		}
+		return samples, -1 << 15, nil
+	case 80:
+		samples := make([]int, len(data))
+		for i, b := range data {
+			samples[i] = int(b) - 128
+		}
+		return samples, -1 << 7, nil
+	case 212:
+		// Two 12-bit samples in three bytes: the low byte of the first, the high nibbles of both, the low byte of the second.
+		samples := make([]int, 0, len(data)*2/3)
+		for i := 0; i+1 < len(data); i += 3 {
+			samples = append(samples, signExtend12(int(data[i])|int(data[i+1]&0x0f)<<8))
+			if i+2 < len(data) {
+				samples = append(samples, signExtend12(int(data[i+2])|int(data[i+1]&0xf0)<<4))
+			}
+		}
+		return samples, -1 << 11, nil
+	}
+	return nil, 0, fmt.Errorf("format %d is not supported, only 16, 61, 80 and 212", format)
+}
+
+func signExtend12(v int) int {
+	if v&0x800 != 0 {
+		return v - 0x1000
+	}
+	return v
+}
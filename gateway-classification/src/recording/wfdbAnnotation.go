@@ -0,0 +1,80 @@
+package recording
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// WFDB annotation files, such as the .st sleep stages of the MIT-BIH Polysomnographic Database.
+//
+// An annotation file is a sequence of 16-bit little-endian words: the 6 high bits are the annotation
+// code, and the 10 low bits the number of samples since the previous annotation. Pseudo-codes carry
+// longer intervals, auxiliary text and fields that are not needed here.
+
+// WFDB annotation pseudo-codes.
+const (
+	wfdbSkip = 59
+	wfdbNum  = 60
+	wfdbSub  = 61
+	wfdbChn  = 62
+	wfdbAux  = 63
+)
+
+// ReadWFDBAnnotations reads a WFDB annotation file of a record sampled at fs Hz.
+func ReadWFDBAnnotations(path string, fs float64) ([]Annotation, error) {
+	if fs <= 0 {
+		return nil, errors.New("the sampling rate of the record is needed to time its annotations")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var annotations []Annotation
+	sample := int64(0)
+	for i := 0; i+1 < len(data); {
+		word := binary.LittleEndian.Uint16(data[i:])
+		i += 2
+		code, value := int(word>>10), int64(word&0x3ff)
+
+		switch code {
+		case 0:
+			if value == 0 {
+				return annotations, nil
+			}
+			// Code 0 with an interval moves the time without an annotation.
+			sample += value
+		case wfdbSkip:
+			// The interval is a 32-bit number, high 16 bits first.
+			if i+4 > len(data) {
+				return nil, fmt.Errorf("%s: truncated skip at byte %d", path, i)
+			}
+			high := int64(binary.LittleEndian.Uint16(data[i:]))
+			low := int64(binary.LittleEndian.Uint16(data[i+2:]))
+			sample += int64(int32(high<<16 | low))
+			i += 4
+		case wfdbNum, wfdbSub, wfdbChn:
+		case wfdbAux:
+			// value bytes of text, padded to an even length, for the previous annotation.
+			end := i + int(value)
+			if end > len(data) {
+				return nil, fmt.Errorf("%s: truncated aux text at byte %d", path, i)
+			}
+			if len(annotations) > 0 {
+				annotations[len(annotations)-1].Text = strings.TrimRight(string(data[i:end]), "\x00")
+			}
+			i = end + int(value%2)
+		default:
+			sample += value
+			annotations = append(annotations, Annotation{
+				Onset: float64(sample) / fs,
+				Code:  code,
+			})
+		}
+	}
+	return annotations, nil
+}
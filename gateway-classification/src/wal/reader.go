@@ -0,0 +1,118 @@
+package wal
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+func newBufferedReader(r io.Reader) *bufio.Reader {
+	return bufio.NewReaderSize(r, 64<<10)
+}
+
+// Reader reads the records of a log in sequence, following the log as records are appended.
+type Reader struct {
+	log *Log
+
+	path   string
+	file   *os.File
+	buf    *bufio.Reader
+	offset int64
+
+	next uint64
+}
+
+// NewReader returns a reader starting at the record with sequence number from.
+func (l *Log) NewReader(from uint64) *Reader {
+	return &Reader{log: l, next: from}
+}
+
+// Next returns the next record. It returns io.EOF when every appended record has been read;
+// Next can be called again after more records are appended.
+func (r *Reader) Next() (Record, error) {
+	for {
+		path, size, last, ok := r.log.segmentFor(r.next)
+		if !ok {
+			return Record{}, io.EOF
+		}
+
+		if path != r.path || r.file == nil {
+			if err := r.open(path); err != nil {
+				return Record{}, err
+			}
+		}
+
+		if r.offset >= size {
+			if last {
+				return Record{}, io.EOF
+			}
+			// The segment is complete, move on to the following one.
+			if first := r.log.segmentAfter(r.path); first > r.next {
+				r.next = first
+			}
+			continue
+		}
+
+		record, n, err := readRecord(r.buf)
+		if err != nil {
+			// The segment is read again from its start on the next call.
+			r.file.Close()
+			r.file = nil
+			return Record{}, err
+		}
+		r.offset += n
+
+		if record.Seq < r.next {
+			continue
+		}
+		r.next = record.Seq + 1
+		return record, nil
+	}
+}
+
+// Skip moves the reader to the first record of the segment following the one it failed to read,
+// giving up the remaining records of the segment, e.g. after ErrInvalidRecord. When it is the segment
+// being appended to, a new segment is started for the following records.
+//
+// It returns the sequence number of the next record to read.
+func (r *Reader) Skip() (uint64, error) {
+	path := r.path
+	if path == "" {
+		path, _, _, _ = r.log.segmentFor(r.next)
+	}
+	r.Close()
+
+	next, err := r.log.skipSegment(path)
+	if err != nil {
+		return r.next, err
+	}
+	if next > r.next {
+		r.next = next
+	}
+	return r.next, nil
+}
+
+// Close closes the segment file opened by the reader.
+func (r *Reader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	r.path = ""
+	return err
+}
+
+func (r *Reader) open(path string) error {
+	r.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	r.path = path
+	r.file = f
+	r.buf = newBufferedReader(f)
+	r.offset = 0
+	return nil
+}
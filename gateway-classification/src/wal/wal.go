@@ -0,0 +1,533 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrLogFull is returned by Append when the log would grow beyond Options.MaxTotalBytes.
+var ErrLogFull = errors.New("wal: log is full")
+
+// ErrClosed is returned when the log is used after Close.
+var ErrClosed = errors.New("wal: log is closed")
+
+// ErrInvalidRecord is returned when a record is torn or corrupted.
+var ErrInvalidRecord = errors.New("wal: invalid record")
+
+const (
+	segmentExt     = ".wal"
+	checkpointName = "checkpoint"
+
+	// headerSize is the size of a record header: length, CRC-32C of the data and sequence number.
+	headerSize = 4 + 4 + 8
+
+	// maxRecordSize bounds the length read from a header, so a corrupted length is not allocated.
+	maxRecordSize = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configures the size limits of a log.
+type Options struct {
+	// MaxSegmentBytes is the size after which a new segment file is started. Defaults to 8 MiB.
+	MaxSegmentBytes int64
+	// MaxTotalBytes is the maximum size of all segments. Zero means unlimited.
+	MaxTotalBytes int64
+}
+
+// Record is an entry of the log.
+type Record struct {
+	Seq  uint64
+	Data []byte
+}
+
+type segment struct {
+	first uint64
+	last  uint64
+	size  int64
+	path  string
+}
+
+// Log is an append-only log of records split into segment files in a directory.
+//
+// Every record gets a sequence number. Records up to the committed sequence number have been
+// consumed; segments holding only committed records are deleted. On Open, a torn or corrupted
+// tail left by a crash is truncated, so the log always ends with a complete record.
+type Log struct {
+	dir  string
+	opts Options
+
+	mu         sync.Mutex
+	segments   []*segment
+	active     *os.File
+	nextSeq    uint64
+	committed  uint64
+	totalBytes int64
+	closed     bool
+
+	notify chan struct{}
+}
+
+// Open opens the log in the given directory, creating it if needed, and recovers it after a crash.
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.MaxSegmentBytes <= 0 {
+		opts.MaxSegmentBytes = 8 << 20
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:    dir,
+		opts:   opts,
+		notify: make(chan struct{}, 1),
+	}
+
+	committed, err := readCheckpoint(filepath.Join(dir, checkpointName))
+	if err != nil {
+		return nil, err
+	}
+	l.committed = committed
+	l.nextSeq = committed + 1
+
+	if err := l.recover(); err != nil {
+		return nil, err
+	}
+
+	if len(l.segments) == 0 {
+		if err := l.startSegment(); err != nil {
+			return nil, err
+		}
+	} else {
+		last := l.segments[len(l.segments)-1]
+		l.active, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// recover loads the segments of the directory and truncates the log at the first invalid record.
+func (l *Log) recover() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), segmentExt) {
+			paths = append(paths, filepath.Join(l.dir, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+
+	for i, path := range paths {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seg := &segment{first: first, path: path}
+		valid, last, scanErr := scanSegment(path)
+		if scanErr != nil && !errors.Is(scanErr, ErrInvalidRecord) {
+			return scanErr
+		}
+		seg.size = valid
+		seg.last = last
+
+		corrupted := errors.Is(scanErr, ErrInvalidRecord)
+		if corrupted {
+			fmt.Printf("wal: truncating %s at offset %d: %v\n", path, valid, scanErr)
+			if err := os.Truncate(path, valid); err != nil {
+				return err
+			}
+			// Records after a corrupted one can not be trusted to be in sequence.
+			for _, stale := range paths[i+1:] {
+				fmt.Printf("wal: removing %s after corrupted segment\n", stale)
+				if err := os.Remove(stale); err != nil {
+					return err
+				}
+			}
+		}
+
+		if seg.size == 0 && !corrupted && i != len(paths)-1 {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		l.segments = append(l.segments, seg)
+		l.totalBytes += seg.size
+		if seg.last >= l.nextSeq {
+			l.nextSeq = seg.last + 1
+		}
+
+		if corrupted {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Append writes a record to the log and syncs it to disk.
+//
+// It returns the sequence number of the record.
+func (l *Log) Append(data []byte) (uint64, error) {
+	return l.AppendBatch([][]byte{data})
+}
+
+// AppendBatch writes several records to the log with a single sync.
+//
+// It returns the sequence number of the last record. Either all records are appended or none is.
+func (l *Log) AppendBatch(batch [][]byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return 0, ErrClosed
+	}
+	if len(batch) == 0 {
+		return l.nextSeq - 1, nil
+	}
+
+	var size int64
+	for _, data := range batch {
+		size += int64(headerSize + len(data))
+	}
+	if l.opts.MaxTotalBytes > 0 && l.totalBytes+size > l.opts.MaxTotalBytes {
+		return 0, ErrLogFull
+	}
+
+	active := l.segments[len(l.segments)-1]
+	if active.size > 0 && active.size+size > l.opts.MaxSegmentBytes {
+		if err := l.startSegment(); err != nil {
+			return 0, err
+		}
+		active = l.segments[len(l.segments)-1]
+	}
+
+	buf := make([]byte, 0, size)
+	seq := l.nextSeq
+	for _, data := range batch {
+		buf = appendRecord(buf, seq, data)
+		seq++
+	}
+
+	if _, err := l.active.Write(buf); err != nil {
+		// Drop a partially written batch so the next append starts on a record boundary.
+		l.active.Truncate(active.size)
+		return 0, err
+	}
+	if err := l.active.Sync(); err != nil {
+		return 0, err
+	}
+
+	active.size += size
+	active.last = seq - 1
+	l.totalBytes += size
+	l.nextSeq = seq
+
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+
+	return seq - 1, nil
+}
+
+// Commit marks every record up to seq as consumed and deletes the segments holding only such records.
+func (l *Log) Commit(seq uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return ErrClosed
+	}
+	if seq <= l.committed {
+		return nil
+	}
+	if seq >= l.nextSeq {
+		seq = l.nextSeq - 1
+	}
+
+	if err := writeCheckpoint(l.dir, seq); err != nil {
+		return err
+	}
+	l.committed = seq
+
+	// The active segment is kept, even when fully committed.
+	for len(l.segments) > 1 && l.segments[0].last <= seq {
+		if err := os.Remove(l.segments[0].path); err != nil {
+			return err
+		}
+		l.totalBytes -= l.segments[0].size
+		l.segments = l.segments[1:]
+	}
+
+	return nil
+}
+
+// Committed returns the sequence number of the last consumed record.
+func (l *Log) Committed() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.committed
+}
+
+// LastSeq returns the sequence number of the last appended record.
+func (l *Log) LastSeq() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.nextSeq - 1
+}
+
+// Pending returns the number of records that have not been committed yet.
+func (l *Log) Pending() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.nextSeq - 1 - l.committed
+}
+
+// Size returns the size in bytes of all segments.
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.totalBytes
+}
+
+// Notify returns a channel that receives a value after records are appended.
+func (l *Log) Notify() <-chan struct{} {
+	return l.notify
+}
+
+// Close closes the active segment. Appended records stay on disk.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	return l.active.Close()
+}
+
+// startSegment closes the active segment and starts a new one at the next sequence number.
+// The caller must hold l.mu, except during Open.
+func (l *Log) startSegment() error {
+	if l.active != nil {
+		if err := l.active.Sync(); err != nil {
+			return err
+		}
+		if err := l.active.Close(); err != nil {
+			return err
+		}
+	}
+
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.nextSeq, segmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(l.dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	l.active = f
+	l.segments = append(l.segments, &segment{first: l.nextSeq, last: l.nextSeq - 1, path: path})
+	return nil
+}
+
+// segmentFor returns the path and the valid size of the segment holding seq, and whether it is the
+// last segment. ok is false if seq is before the first segment.
+func (l *Log) segmentFor(seq uint64) (path string, size int64, last bool, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := len(l.segments) - 1; i >= 0; i-- {
+		seg := l.segments[i]
+		if seg.first <= seq {
+			return seg.path, seg.size, i == len(l.segments)-1, true
+		}
+	}
+	if len(l.segments) > 0 {
+		seg := l.segments[0]
+		return seg.path, seg.size, len(l.segments) == 1, true
+	}
+	return "", 0, true, false
+}
+
+// segmentAfter returns the first sequence number of the segment following the one at path.
+func (l *Log) segmentAfter(path string) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, seg := range l.segments {
+		if seg.path == path && i+1 < len(l.segments) {
+			return l.segments[i+1].first
+		}
+	}
+	return l.nextSeq
+}
+
+// skipSegment returns the first sequence number of the segment following the one at path. When it is
+// the active segment, a new one is started so that the following records can be read.
+func (l *Log) skipSegment(path string) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, seg := range l.segments {
+		if seg.path != path {
+			continue
+		}
+		if i+1 < len(l.segments) {
+			return l.segments[i+1].first, nil
+		}
+		if !l.closed && seg.size > 0 {
+			if err := l.startSegment(); err != nil {
+				return 0, err
+			}
+		}
+		break
+	}
+	return l.nextSeq, nil
+}
+
+func appendRecord(buf []byte, seq uint64, data []byte) []byte {
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(data, crcTable))
+	binary.LittleEndian.PutUint64(header[8:16], seq)
+	buf = append(buf, header[:]...)
+	return append(buf, data...)
+}
+
+// readRecord reads the record at the current position of r.
+// It returns io.EOF at the end of r and ErrInvalidRecord for a torn or corrupted record.
+func readRecord(r io.Reader) (Record, int64, error) {
+	var header [headerSize]byte
+	n, err := io.ReadFull(r, header[:])
+	if err == io.EOF {
+		return Record{}, 0, io.EOF
+	}
+	if err == io.ErrUnexpectedEOF {
+		return Record{}, 0, fmt.Errorf("%w: torn header", ErrInvalidRecord)
+	}
+	if err != nil {
+		return Record{}, 0, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return Record{}, 0, fmt.Errorf("%w: length %d", ErrInvalidRecord, length)
+	}
+	data := make([]byte, length)
+	m, err := io.ReadFull(r, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return Record{}, 0, fmt.Errorf("%w: torn data", ErrInvalidRecord)
+	}
+	if err != nil {
+		return Record{}, 0, err
+	}
+
+	if crc32.Checksum(data, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return Record{}, 0, fmt.Errorf("%w: checksum mismatch", ErrInvalidRecord)
+	}
+
+	record := Record{Seq: binary.LittleEndian.Uint64(header[8:16]), Data: data}
+	return record, int64(n + m), nil
+}
+
+// scanSegment returns the size of the valid prefix of a segment and the sequence number of its last record.
+func scanSegment(path string) (int64, uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	r := newBufferedReader(f)
+	var valid int64
+	var last uint64
+	for {
+		record, n, err := readRecord(r)
+		if err == io.EOF {
+			return valid, last, nil
+		}
+		if err != nil {
+			return valid, last, err
+		}
+		if last != 0 && record.Seq != last+1 {
+			return valid, last, fmt.Errorf("%w: sequence %d after %d", ErrInvalidRecord, record.Seq, last)
+		}
+		valid += n
+		last = record.Seq
+	}
+}
+
+func readCheckpoint(path string) (uint64, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 12 || crc32.Checksum(b[:8], crcTable) != binary.LittleEndian.Uint32(b[8:]) {
+		return 0, fmt.Errorf("wal: corrupted checkpoint %s", path)
+	}
+	return binary.LittleEndian.Uint64(b[:8]), nil
+}
+
+// writeCheckpoint atomically replaces the checkpoint file of dir.
+func writeCheckpoint(dir string, seq uint64) error {
+	var b [12]byte
+	binary.LittleEndian.PutUint64(b[:8], seq)
+	binary.LittleEndian.PutUint32(b[8:], crc32.Checksum(b[:8], crcTable))
+
+	tmp := filepath.Join(dir, checkpointName+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b[:]); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, checkpointName)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,220 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openLog(t *testing.T, dir string, opts Options) *Log {
+	t.Helper()
+	l, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func appendRecords(t *testing.T, l *Log, from, to int) {
+	t.Helper()
+	for i := from; i <= to; i++ {
+		if _, err := l.Append([]byte(fmt.Sprintf("record %d", i))); err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+	}
+}
+
+// readAll reads the records of the log, starting at from, until io.EOF.
+func readAll(t *testing.T, l *Log, from uint64) []Record {
+	t.Helper()
+	r := l.NewReader(from)
+	defer r.Close()
+
+	var records []Record
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		records = append(records, record)
+	}
+}
+
+func segmentPath(dir string, first uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", first, segmentExt))
+}
+
+func TestTornTailIsTruncatedOnReopen(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, Options{})
+	appendRecords(t, l, 1, 3)
+	size := l.Size()
+	l.Close()
+
+	// A crash in the middle of an append leaves half a record behind.
+	f, err := os.OpenFile(segmentPath(dir, 1), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(appendRecord(nil, 4, []byte("record 4"))[:headerSize+3])
+	f.Close()
+
+	l = openLog(t, dir, Options{})
+	if l.LastSeq() != 3 {
+		t.Errorf("LastSeq = %d, want 3", l.LastSeq())
+	}
+	if l.Size() != size {
+		t.Errorf("Size = %d, want %d", l.Size(), size)
+	}
+	info, err := os.Stat(segmentPath(dir, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != size {
+		t.Errorf("segment is %d bytes, want %d", info.Size(), size)
+	}
+
+	appendRecords(t, l, 4, 4)
+	records := readAll(t, l, 1)
+	if len(records) != 4 || string(records[3].Data) != "record 4" || records[3].Seq != 4 {
+		t.Errorf("read %d records after the append, last %+v", len(records), records[len(records)-1])
+	}
+}
+
+func TestCorruptedRecordTruncatesTheLog(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, Options{})
+	appendRecords(t, l, 1, 3)
+	l.Close()
+
+	// Flip a byte of the data of the second record, so its checksum no longer matches.
+	path := segmentPath(dir, 1)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordSize := headerSize + len("record 1")
+	data[recordSize+headerSize] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	l = openLog(t, dir, Options{})
+	if l.LastSeq() != 1 {
+		t.Errorf("LastSeq = %d, want 1", l.LastSeq())
+	}
+	records := readAll(t, l, 1)
+	if len(records) != 1 || string(records[0].Data) != "record 1" {
+		t.Errorf("read %v, want only record 1", records)
+	}
+}
+
+func TestReaderSkipsCorruptedActiveSegment(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, dir, Options{})
+	appendRecords(t, l, 1, 3)
+
+	path := segmentPath(dir, 1)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := l.NewReader(1)
+	defer r.Close()
+	for seq := uint64(1); seq <= 2; seq++ {
+		if record, err := r.Next(); err != nil || record.Seq != seq {
+			t.Fatalf("Next = %d, %v, want %d", record.Seq, err, seq)
+		}
+	}
+	if _, err := r.Next(); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("Next = %v, want ErrInvalidRecord", err)
+	}
+
+	next, err := r.Skip()
+	if err != nil {
+		t.Fatalf("Skip: %v", err)
+	}
+	if next != 4 {
+		t.Errorf("Skip = %d, want 4", next)
+	}
+
+	appendRecords(t, l, 4, 5)
+	for seq := uint64(4); seq <= 5; seq++ {
+		if record, err := r.Next(); err != nil || record.Seq != seq {
+			t.Fatalf("Next = %d, %v, want %d", record.Seq, err, seq)
+		}
+	}
+}
+
+func TestReopenAfterCommit(t *testing.T) {
+	dir := t.TempDir()
+	// Small segments, so that committed ones are deleted.
+	opts := Options{MaxSegmentBytes: 64}
+	l := openLog(t, dir, opts)
+	appendRecords(t, l, 1, 10)
+	if err := l.Commit(6); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	l.Close()
+
+	l = openLog(t, dir, opts)
+	if l.Committed() != 6 {
+		t.Errorf("Committed = %d, want 6", l.Committed())
+	}
+	if l.Pending() != 4 {
+		t.Errorf("Pending = %d, want 4", l.Pending())
+	}
+	if l.LastSeq() != 10 {
+		t.Errorf("LastSeq = %d, want 10", l.LastSeq())
+	}
+	if _, err := os.Stat(segmentPath(dir, 1)); !os.IsNotExist(err) {
+		t.Errorf("committed segment was not deleted: %v", err)
+	}
+
+	records := readAll(t, l, l.Committed()+1)
+	if len(records) != 4 || records[0].Seq != 7 || records[3].Seq != 10 {
+		t.Errorf("read %d records from 7, want 7 to 10", len(records))
+	}
+
+	seq, err := l.Append([]byte("record 11"))
+	if err != nil || seq != 11 {
+		t.Errorf("Append = %d, %v, want 11", seq, err)
+	}
+}
+
+func TestLogFull(t *testing.T) {
+	dir := t.TempDir()
+	recordSize := int64(headerSize + len("record 1"))
+	l := openLog(t, dir, Options{MaxSegmentBytes: 2 * recordSize, MaxTotalBytes: 3 * recordSize})
+	appendRecords(t, l, 1, 3)
+
+	if _, err := l.Append([]byte("record 4")); !errors.Is(err, ErrLogFull) {
+		t.Fatalf("Append = %v, want ErrLogFull", err)
+	}
+	if _, err := l.AppendBatch([][]byte{[]byte("a"), []byte("b")}); !errors.Is(err, ErrLogFull) {
+		t.Fatalf("AppendBatch = %v, want ErrLogFull", err)
+	}
+	if l.LastSeq() != 3 {
+		t.Errorf("LastSeq = %d, want 3", l.LastSeq())
+	}
+
+	// Committing frees the first segment and makes room again.
+	if err := l.Commit(2); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if seq, err := l.Append([]byte("record 4")); err != nil || seq != 4 {
+		t.Errorf("Append after commit = %d, %v, want 4", seq, err)
+	}
+}
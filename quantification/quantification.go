@@ -32,6 +32,12 @@ type Message struct {
 	Data  interface{} `json:"data"`
 }
 
+// SleepStageReady is the data of the "sleepStageReady" event published by the gateway when a session is classified.
+type SleepStageReady struct {
+	SessionID uint `json:"session_id"`
+	PatientID uint `json:"patient_id"`
+}
+
 type SleepQuality struct {
 	ID                 uint      `gorm:"primaryKey" json:"id"`
 	Value              string    `json:"value,omitempty"`
@@ -132,7 +138,19 @@ var messagePubHandler MQTT.MessageHandler = func(client MQTT.Client, msg MQTT.Me
 	fmt.Printf("Message Received from [%s] : %s\n", msg.Topic(), msg.Payload())
 
 	if string(msg.Payload()) == "sleepStageReady" {
-		quantifyData()
+		quantifyData(SleepStageReady{PatientID: 1})
+		return
+	}
+
+	var receivedMessage struct {
+		Event string          `json:"event"`
+		Data  SleepStageReady `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Payload(), &receivedMessage); err != nil {
+		return
+	}
+	if receivedMessage.Event == "sleepStageReady" && receivedMessage.Data.SessionID != 0 {
+		quantifyData(receivedMessage.Data)
 	}
 }
 
@@ -162,7 +180,20 @@ func setUpDB() *sql.DB {
 
 var red *redis.Client
 
-func quantifyData() {
+// legacyStageDuration is the duration of a sleep stage stored before stages had epoch times.
+const legacyStageDuration = time.Minute
+
+// epochHours returns the duration in hours of the epoch of a sleep stage.
+func epochHours(start, end time.Time) float64 {
+	if start.IsZero() || !end.After(start) {
+		return legacyStageDuration.Hours()
+	}
+	return end.Sub(start).Hours()
+}
+
+// quantifyData computes the sleep quality of a session.
+// A zero SessionID quantifies every stored sleep stage.
+func quantifyData(ready SleepStageReady) {
 
 	var awake float64 = 0
 	var deepSleep float64 = 0
@@ -170,11 +201,20 @@ func quantifyData() {
 
 	db := setUpDB()
 	DB = db
-	value, err := red.Get(red.Context(), "sleep-stages").Result()
+	cacheKey := "sleep-stages"
+	query := "SELECT value, start_time, end_time FROM sleep_stages"
+	var args []any
+	if ready.SessionID != 0 {
+		cacheKey = fmt.Sprintf("sleep-stages-%d", ready.SessionID)
+		query += " WHERE sleep_data_id = $1"
+		args = append(args, ready.SessionID)
+	}
+
+	value, err := red.Get(red.Context(), cacheKey).Result()
 	if value == "" || err != nil {
 		// Example query
 		start := time.Now().UnixMilli()
-		rows, err := DB.Query("SELECT * FROM sleep_stages")
+		rows, err := DB.Query(query, args...)
 		end := time.Now().UnixMilli()
 		fmt.Println("Start : ", start)
 		fmt.Println("End : ", end)
@@ -186,24 +226,22 @@ func quantifyData() {
 
 		// Iterate over the rows
 		for rows.Next() {
-			var id int
-			var reference_id string
 			var value string
-			var method any
-			var timestamp time.Time
-			err := rows.Scan(&id, &reference_id, &value, &method, &timestamp)
+			var startTime, endTime sql.NullTime
+			err := rows.Scan(&value, &startTime, &endTime)
 			if err != nil {
 				log.Println("Error scanning row: ", err)
 			}
+			hours := epochHours(startTime.Time, endTime.Time)
 			if value == "N1" || value == "N2" || value == "REM" {
-				totalSleep++
+				totalSleep += hours
 			}
 			if value == "N3" {
-				totalSleep++
-				deepSleep++
+				totalSleep += hours
+				deepSleep += hours
 			}
 			if value == "AWAKE" {
-				awake++
+				awake += hours
 			}
 		}
 		if err := rows.Err(); err != nil {
@@ -216,6 +254,8 @@ func quantifyData() {
 			Value       string    `json:"value"`
 			Method      string    `json:"method"`
 			Timestamp   time.Time `json:"timestamp"`
+			StartTime   time.Time `json:"start_time"`
+			EndTime     time.Time `json:"end_time"`
 		}
 
 		type SleepStages struct {
@@ -231,15 +271,16 @@ func quantifyData() {
 
 		// Loop through the stages and print values
 		for _, stage := range stages.Stages {
+			hours := epochHours(stage.StartTime, stage.EndTime)
 			if stage.Value == "N1" || stage.Value == "N2" || stage.Value == "REM" {
-				totalSleep++
+				totalSleep += hours
 			}
 			if stage.Value == "N3" {
-				totalSleep++
-				deepSleep++
+				totalSleep += hours
+				deepSleep += hours
 			}
 			if stage.Value == "AWAKE" {
-				awake++
+				awake += hours
 			}
 		}
 	}
@@ -271,9 +312,9 @@ func quantifyData() {
 	}
 
 	inputValues := map[string]float64{
-		"awakeDuration":  awake / 60,
-		"deepSleepTime":  deepSleep / 60,
-		"totalSleepTime": totalSleep / 60,
+		"awakeDuration":  awake,
+		"deepSleepTime":  deepSleep,
+		"totalSleepTime": totalSleep,
 	}
 
 	// Fuzzify the sleep data
@@ -318,7 +359,7 @@ func quantifyData() {
 		TotalSleepDuration: inputValues["totalSleepTime"],
 		AwakeDuration:      inputValues["awakeDuration"],
 		InputTime:          time.Now(),
-		UserId:             1,
+		UserId:             ready.PatientID,
 	}
 
 	sleepQualityJSON, err := json.Marshal(sleepQuality)
@@ -327,7 +368,7 @@ func quantifyData() {
 		return
 	}
 
-	err = red.Set(red.Context(), "sleep_quality_1", sleepQualityJSON, 0).Err()
+	err = red.Set(red.Context(), fmt.Sprintf("sleep_quality_%d", ready.PatientID), sleepQualityJSON, 0).Err()
 	if err != nil {
 		log.Fatalf("Failed to save to redis: %v", err)
 	} else {
@@ -347,7 +388,7 @@ func quantifyData() {
 	value3 := inputValues["awakeDuration"]
 	value4 := inputValues["totalSleepTime"]
 	value5 := time.Now()
-	value6 := 1
+	value6 := ready.PatientID
 
 	// Execute the SQL statement with the provided values.
 	_, err = stmt.Exec(value1, value2, value3, value4, value5, value6)